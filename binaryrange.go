@@ -0,0 +1,326 @@
+package hibp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// The binary range format stores the suffixes of a range as packed hex, i.e., two characters per byte, followed by
+// the counts encoded as varints:
+//
+//	suffix length in hex characters (uint8) | flags (uint8) | number of records (uint32)
+//	suffixes: the hex characters of all suffixes, packed back to back, two per byte
+//	checkpoints: the offset of every 64th count within the counts (uint32)
+//	counts: the counts of all records (uvarint)
+//
+// All integers are encoded in big endian byte order.
+// As suffixes have a fixed length, the record containing a suffix can be found using binary search; the checkpoints
+// limit the number of varints that have to be decoded to find its count.
+// The format does not use compression, as packed hashes are not compressible anyway.
+const (
+	binaryRangeHeaderSize    = 6
+	binaryRangeCheckpointGap = 64
+
+	// binaryRangeFlagTrailingCRLF records that the last line of the range is terminated by CRLF as well.
+	binaryRangeFlagTrailingCRLF = 1 << 0
+)
+
+var crlf = []byte("\r\n")
+
+// errNotBinaryRepresentable is returned for data that cannot be stored in the binary range format without altering it,
+// e.g., because it is not sorted or contains lower-case hex characters.
+var errNotBinaryRepresentable = errors.New("data cannot be represented in the binary range format")
+
+type binaryRangeHeader struct {
+	suffixLength int
+	flags        byte
+	records      int
+}
+
+// encodeBinaryRange converts a range from the text format of the upstream API into the binary range format.
+// Only data that is rendered back to exactly the same text is accepted.
+func encodeBinaryRange(data []byte) ([]byte, error) {
+	var flags byte
+
+	lines := data
+	if bytes.HasSuffix(lines, crlf) {
+		flags |= binaryRangeFlagTrailingCRLF
+		lines = lines[:len(lines)-len(crlf)]
+	}
+
+	var (
+		suffixes     [][]byte
+		counts       []uint64
+		suffixLength int
+	)
+
+	for len(lines) > 0 {
+		var line []byte
+		line, lines, _ = bytes.Cut(lines, crlf)
+
+		suffix, count, found := bytes.Cut(line, []byte(":"))
+		if !found {
+			return nil, fmt.Errorf("%w: malformed line %q", errNotBinaryRepresentable, line)
+		}
+
+		if suffixLength == 0 {
+			suffixLength = len(suffix)
+		}
+
+		if len(suffix) != suffixLength || suffixLength > 0xFF {
+			return nil, fmt.Errorf("%w: suffix %q has an unexpected length", errNotBinaryRepresentable, suffix)
+		}
+
+		if len(suffixes) > 0 && bytes.Compare(suffixes[len(suffixes)-1], suffix) >= 0 {
+			return nil, fmt.Errorf("%w: suffix %q is out of order", errNotBinaryRepresentable, suffix)
+		}
+
+		n, err := strconv.ParseUint(string(count), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid count in line %q", errNotBinaryRepresentable, line)
+		}
+
+		suffixes = append(suffixes, suffix)
+		counts = append(counts, n)
+	}
+
+	header := binaryRangeHeader{suffixLength: suffixLength, flags: flags, records: len(suffixes)}
+
+	packed := make([]byte, header.suffixesSize())
+	for i, suffix := range suffixes {
+		for j, c := range suffix {
+			nibble, ok := hexNibble(c)
+			if !ok {
+				return nil, fmt.Errorf("%w: suffix %q is not hex-encoded", errNotBinaryRepresentable, suffix)
+			}
+
+			setNibble(packed, i*suffixLength+j, nibble)
+		}
+	}
+
+	var (
+		checkpoints   = make([]byte, 0, header.checkpointsSize())
+		encodedCounts []byte
+	)
+
+	for i, n := range counts {
+		if i%binaryRangeCheckpointGap == 0 {
+			checkpoints = binary.BigEndian.AppendUint32(checkpoints, uint32(len(encodedCounts)))
+		}
+
+		encodedCounts = binary.AppendUvarint(encodedCounts, n)
+	}
+
+	encoded := make([]byte, 0, binaryRangeHeaderSize+len(packed)+len(checkpoints)+len(encodedCounts))
+	encoded = append(encoded, byte(suffixLength), flags)
+	encoded = binary.BigEndian.AppendUint32(encoded, uint32(len(suffixes)))
+	encoded = append(encoded, packed...)
+	encoded = append(encoded, checkpoints...)
+	encoded = append(encoded, encodedCounts...)
+
+	// Parsing is lenient, e.g., regarding the case of hex characters or leading zeros of counts.
+	// Rejecting everything that does not survive the round trip ensures that the data is never altered.
+	rendered, err := renderBinaryRange(encoded)
+	if err != nil || !bytes.Equal(rendered, data) {
+		return nil, fmt.Errorf("%w: rendering does not reproduce the data", errNotBinaryRepresentable)
+	}
+
+	return encoded, nil
+}
+
+// renderBinaryRange converts a range from the binary range format back into the text format of the upstream API.
+func renderBinaryRange(encoded []byte) ([]byte, error) {
+	header, err := decodeBinaryRangeHeader(encoded)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(encoded) < header.countsOffset() {
+		return nil, errors.New("binary range is truncated")
+	}
+
+	packed := encoded[binaryRangeHeaderSize:]
+	counts := encoded[header.countsOffset():]
+
+	rendered := make([]byte, 0, header.records*(header.suffixLength+8))
+
+	for i := 0; i < header.records; i++ {
+		if i > 0 {
+			rendered = append(rendered, crlf...)
+		}
+
+		for j := 0; j < header.suffixLength; j++ {
+			rendered = append(rendered, hexDigits[getNibble(packed, i*header.suffixLength+j)])
+		}
+
+		n, length := binary.Uvarint(counts)
+		if length <= 0 {
+			return nil, fmt.Errorf("binary range has an invalid count for record %d", i)
+		}
+
+		counts = counts[length:]
+
+		rendered = append(rendered, ':')
+		rendered = strconv.AppendUint(rendered, n, 10)
+	}
+
+	if header.flags&binaryRangeFlagTrailingCRLF != 0 {
+		rendered = append(rendered, crlf...)
+	}
+
+	return rendered, nil
+}
+
+// lookupBinaryRange searches for the given suffix in a range stored in the binary range format, starting at offset
+// base of r.
+// Only the parts of the range needed for the binary search are read.
+func lookupBinaryRange(r io.ReaderAt, base int64, suffix string) (int64, bool, error) {
+	rawHeader := make([]byte, binaryRangeHeaderSize)
+	if _, err := r.ReadAt(rawHeader, base); err != nil {
+		return 0, false, fmt.Errorf("reading header: %w", err)
+	}
+
+	header, err := decodeBinaryRangeHeader(rawHeader)
+	if err != nil {
+		return 0, false, err
+	}
+
+	if len(suffix) != header.suffixLength {
+		return 0, false, nil
+	}
+
+	wanted := make([]byte, len(suffix))
+	for i := range suffix {
+		nibble, ok := hexNibble(suffix[i])
+		if !ok {
+			return 0, false, fmt.Errorf("invalid suffix %q", suffix)
+		}
+
+		wanted[i] = nibble
+	}
+
+	// One extra byte covers suffixes starting in the middle of a byte
+	buf := make([]byte, (header.suffixLength+1)/2+1)
+
+	lo, hi := 0, header.records
+
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+
+		first := mid * header.suffixLength
+		start := first / 2
+		end := min((first+header.suffixLength+1)/2, header.suffixesSize())
+
+		if _, err := r.ReadAt(buf[:end-start], base+int64(binaryRangeHeaderSize+start)); err != nil {
+			return 0, false, fmt.Errorf("reading record %d: %w", mid, err)
+		}
+
+		cmp := 0
+		for j := 0; j < header.suffixLength && cmp == 0; j++ {
+			cmp = int(getNibble(buf, first-start*2+j)) - int(wanted[j])
+		}
+
+		switch {
+		case cmp < 0:
+			lo = mid + 1
+		case cmp > 0:
+			hi = mid
+		default:
+			n, err := readBinaryRangeCount(r, base, header, mid)
+			return n, err == nil, err
+		}
+	}
+
+	return 0, false, nil
+}
+
+func readBinaryRangeCount(r io.ReaderAt, base int64, header binaryRangeHeader, record int) (int64, error) {
+	checkpoint := make([]byte, 4)
+	if _, err := r.ReadAt(checkpoint, base+int64(header.checkpointsOffset()+record/binaryRangeCheckpointGap*4)); err != nil {
+		return 0, fmt.Errorf("reading checkpoint of record %d: %w", record, err)
+	}
+
+	// The counts following the checkpoint, at most binary.MaxVarintLen64 bytes each; the range might end earlier
+	counts := make([]byte, binaryRangeCheckpointGap*binary.MaxVarintLen64)
+
+	n, err := r.ReadAt(counts, base+int64(header.countsOffset())+int64(binary.BigEndian.Uint32(checkpoint)))
+	if err != nil && !errors.Is(err, io.EOF) {
+		return 0, fmt.Errorf("reading counts of record %d: %w", record, err)
+	}
+
+	counts = counts[:n]
+
+	for i := record - record%binaryRangeCheckpointGap; ; i++ {
+		count, length := binary.Uvarint(counts)
+		if length <= 0 {
+			return 0, fmt.Errorf("binary range has an invalid count for record %d", i)
+		}
+
+		if i == record {
+			return int64(count), nil
+		}
+
+		counts = counts[length:]
+	}
+}
+
+func decodeBinaryRangeHeader(encoded []byte) (binaryRangeHeader, error) {
+	if len(encoded) < binaryRangeHeaderSize {
+		return binaryRangeHeader{}, errors.New("binary range is truncated")
+	}
+
+	return binaryRangeHeader{
+		suffixLength: int(encoded[0]),
+		flags:        encoded[1],
+		records:      int(binary.BigEndian.Uint32(encoded[2:])),
+	}, nil
+}
+
+func (h binaryRangeHeader) suffixesSize() int {
+	return (h.records*h.suffixLength + 1) / 2
+}
+
+func (h binaryRangeHeader) checkpointsSize() int {
+	return (h.records + binaryRangeCheckpointGap - 1) / binaryRangeCheckpointGap * 4
+}
+
+func (h binaryRangeHeader) checkpointsOffset() int {
+	return binaryRangeHeaderSize + h.suffixesSize()
+}
+
+func (h binaryRangeHeader) countsOffset() int {
+	return h.checkpointsOffset() + h.checkpointsSize()
+}
+
+func hexNibble(c byte) (byte, bool) {
+	switch {
+	case c >= '0' && c <= '9':
+		return c - '0', true
+	case c >= 'A' && c <= 'F':
+		return c - 'A' + 10, true
+	case c >= 'a' && c <= 'f':
+		return c - 'a' + 10, true
+	default:
+		return 0, false
+	}
+}
+
+func getNibble(packed []byte, i int) byte {
+	if i%2 == 0 {
+		return packed[i/2] >> 4
+	}
+
+	return packed[i/2] & 0x0F
+}
+
+func setNibble(packed []byte, i int, nibble byte) {
+	if i%2 == 0 {
+		packed[i/2] |= nibble << 4
+	} else {
+		packed[i/2] |= nibble
+	}
+}
@@ -0,0 +1,88 @@
+package hibp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestBinaryRange(t *testing.T) {
+	var lines []string
+	for i := 0; i < 1000; i++ {
+		// Every other suffix, so that there are gaps to look up
+		lines = append(lines, fmt.Sprintf("%035X:%d", i*2, i*i))
+	}
+
+	for name, tc := range map[string]struct {
+		data         string
+		suffixLength int
+	}{
+		"empty":         {data: ""},
+		"single line":   {data: "0005AD76BD555C1D6D771DE417A4B87E4B4:10", suffixLength: 35},
+		"trailing CRLF": {data: "0005AD76BD555C1D6D771DE417A4B87E4B4:10\r\n000A8DAE4228F821FB418F59826079BF368:4\r\n", suffixLength: 35},
+		"ntlm":          {data: "0001256EB3A7E8DF8CD1C11C4F0:3\r\n0002A9A6C6AE9D1B47DD1DFE0AB:0", suffixLength: 27},
+		"many lines":    {data: strings.Join(lines, "\r\n"), suffixLength: 35},
+	} {
+		t.Run(name, func(t *testing.T) {
+			encoded, err := encodeBinaryRange([]byte(tc.data))
+			if err != nil {
+				t.Fatalf("encoding: %v", err)
+			}
+
+			rendered, err := renderBinaryRange(encoded)
+			if err != nil {
+				t.Fatalf("rendering: %v", err)
+			}
+
+			if string(rendered) != tc.data {
+				t.Fatalf("rendering does not reproduce the data, got %q", rendered)
+			}
+
+			for _, line := range bytes.Split(bytes.TrimSuffix([]byte(tc.data), crlf), crlf) {
+				if len(line) == 0 {
+					continue
+				}
+
+				suffix, count, _ := bytes.Cut(line, []byte(":"))
+
+				n, found, err := lookupBinaryRange(bytes.NewReader(encoded), 0, string(suffix))
+				if err != nil {
+					t.Fatalf("looking up %q: %v", suffix, err)
+				}
+
+				if !found || fmt.Sprint(n) != string(count) {
+					t.Fatalf("unexpected result for %q: found=%v, count=%d", suffix, found, n)
+				}
+			}
+
+			if tc.suffixLength == 0 {
+				return
+			}
+
+			for _, missing := range []string{fmt.Sprintf("%0*X", tc.suffixLength, 1), strings.Repeat("F", tc.suffixLength)} {
+				if _, found, err := lookupBinaryRange(bytes.NewReader(encoded), 0, missing); err != nil || found {
+					t.Fatalf("expected %q to be missing, got found=%v, err=%v", missing, found, err)
+				}
+			}
+		})
+	}
+}
+
+func TestBinaryRangeRejectsAlteringData(t *testing.T) {
+	for name, data := range map[string]string{
+		"unsorted":       "000A8DAE4228F821FB418F59826079BF368:4\r\n0005AD76BD555C1D6D771DE417A4B87E4B4:10",
+		"lower-case":     "000a8dae4228f821fb418f59826079bf368:4",
+		"leading zeros":  "000A8DAE4228F821FB418F59826079BF368:04",
+		"varying length": "0005AD76BD555C1D6D771DE417A4B87E4B4:10\r\n000A8DAE4228F821FB418F59826079BF36:4",
+		"not hex":        "000X8DAE4228F821FB418F59826079BF368:4",
+		"LF only":        "0005AD76BD555C1D6D771DE417A4B87E4B4:10\n000A8DAE4228F821FB418F59826079BF368:4",
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := encodeBinaryRange([]byte(data)); !errors.Is(err, errNotBinaryRepresentable) {
+				t.Fatalf("expected data to be rejected, got %v", err)
+			}
+		})
+	}
+}
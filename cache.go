@@ -0,0 +1,165 @@
+package hibp
+
+import (
+	"container/list"
+	"os"
+	syncPkg "sync"
+)
+
+// rangeCacheEntryOverhead approximates the memory an entry of the range cache occupies in addition to its data.
+const rangeCacheEntryOverhead = 128
+
+// CacheStats describes the usage of the range cache, see WithCache.
+type CacheStats struct {
+	// Hits is the number of reads that have been served from the cache.
+	Hits int64
+	// Misses is the number of reads that had to load the range from disk.
+	Misses int64
+	// Evictions is the number of ranges that have been dropped to stay within the size limit.
+	Evictions int64
+	// Entries is the number of ranges currently cached.
+	Entries int
+	// Bytes is the approximate size of the ranges currently cached.
+	Bytes int64
+}
+
+// rangeCache is an LRU cache of decoded ranges, bounded by their total size.
+// It is shared by the storages of all hash families of an instance.
+// Entries remember the file they have been loaded from, so they are not served anymore once the file has been
+// replaced, e.g., by another process or by switching to another generation in snapshot mode.
+type rangeCache struct {
+	lock     syncPkg.Mutex
+	maxBytes int64
+	entries  map[rangeCacheKey]*list.Element
+	lru      *list.List // front is the most recently used entry
+	stats    CacheStats
+}
+
+type rangeCacheKey struct {
+	owner *fsStorage
+	key   string
+}
+
+type rangeCacheEntry struct {
+	rangeCacheKey
+	etag string
+	data []byte
+	file os.FileInfo
+}
+
+func (e *rangeCacheEntry) size() int64 {
+	return int64(len(e.etag)+len(e.data)) + rangeCacheEntryOverhead
+}
+
+func newRangeCache(maxBytes int64) *rangeCache {
+	return &rangeCache{
+		maxBytes: maxBytes,
+		entries:  make(map[rangeCacheKey]*list.Element),
+		lru:      list.New(),
+	}
+}
+
+// get returns the cached range if it has been loaded from the given file.
+func (c *rangeCache) get(owner *fsStorage, key string, file os.FileInfo) (*rangeCacheEntry, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	element, exists := c.entries[rangeCacheKey{owner: owner, key: key}]
+	if !exists {
+		c.stats.Misses++
+
+		return nil, false
+	}
+
+	entry := element.Value.(*rangeCacheEntry)
+
+	if !sameFile(entry.file, file) {
+		c.remove(element)
+		c.stats.Misses++
+
+		return nil, false
+	}
+
+	c.lru.MoveToFront(element)
+	c.stats.Hits++
+
+	return entry, true
+}
+
+// peek returns the cached range if it has been loaded from the given file, without counting it as a use of the range.
+func (c *rangeCache) peek(owner *fsStorage, key string, file os.FileInfo) (*rangeCacheEntry, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	element, exists := c.entries[rangeCacheKey{owner: owner, key: key}]
+	if !exists {
+		return nil, false
+	}
+
+	entry := element.Value.(*rangeCacheEntry)
+
+	return entry, sameFile(entry.file, file)
+}
+
+// put caches a range, evicting the least recently used ones as necessary.
+// Ranges exceeding the size of the cache as a whole are not cached.
+func (c *rangeCache) put(owner *fsStorage, key, etag string, data []byte, file os.FileInfo) {
+	entry := &rangeCacheEntry{
+		rangeCacheKey: rangeCacheKey{owner: owner, key: key},
+		etag:          etag,
+		data:          data,
+		file:          file,
+	}
+
+	if entry.size() > c.maxBytes {
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if element, exists := c.entries[entry.rangeCacheKey]; exists {
+		c.remove(element)
+	}
+
+	c.entries[entry.rangeCacheKey] = c.lru.PushFront(entry)
+	c.stats.Bytes += entry.size()
+
+	for c.stats.Bytes > c.maxBytes {
+		c.remove(c.lru.Back())
+		c.stats.Evictions++
+	}
+}
+
+// invalidate drops a range, e.g., because it gets replaced.
+func (c *rangeCache) invalidate(owner *fsStorage, key string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if element, exists := c.entries[rangeCacheKey{owner: owner, key: key}]; exists {
+		c.remove(element)
+	}
+}
+
+func (c *rangeCache) remove(element *list.Element) {
+	entry := c.lru.Remove(element).(*rangeCacheEntry)
+
+	delete(c.entries, entry.rangeCacheKey)
+	c.stats.Bytes -= entry.size()
+}
+
+func (c *rangeCache) statistics() CacheStats {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	stats := c.stats
+	stats.Entries = len(c.entries)
+
+	return stats
+}
+
+// sameFile reports whether both infos describe the same version of a file.
+// Comparing size and modification time as well guards against inode numbers being reused for replacing files.
+func sameFile(a, b os.FileInfo) bool {
+	return os.SameFile(a, b) && a.Size() == b.Size() && a.ModTime().Equal(b.ModTime())
+}
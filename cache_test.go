@@ -0,0 +1,171 @@
+package hibp
+
+import (
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestRangeCacheEviction(t *testing.T) {
+	owner := &fsStorage{}
+
+	file, err := os.Stat(t.TempDir())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	data := []byte(strings.Repeat("x", 100))
+	entrySize := int64(len(data)) + rangeCacheEntryOverhead
+
+	cache := newRangeCache(2 * entrySize)
+
+	cache.put(owner, "00000", "", data, file)
+	cache.put(owner, "00001", "", data, file)
+
+	// Using the first range makes the second one the least recently used
+	if _, ok := cache.get(owner, "00000", file); !ok {
+		t.Fatal("expected range 00000 to be cached")
+	}
+
+	cache.put(owner, "00002", "", data, file)
+
+	if _, ok := cache.get(owner, "00001", file); ok {
+		t.Fatal("expected range 00001 to be evicted")
+	}
+
+	if _, ok := cache.get(owner, "00002", file); !ok {
+		t.Fatal("expected range 00002 to be cached")
+	}
+
+	// Ranges exceeding the cache as a whole are not cached at all
+	cache.put(owner, "00003", "", []byte(strings.Repeat("x", 1000)), file)
+
+	if _, ok := cache.get(owner, "00003", file); ok {
+		t.Fatal("expected range 00003 to not be cached")
+	}
+
+	expected := CacheStats{Hits: 2, Misses: 2, Evictions: 1, Entries: 2, Bytes: 2 * entrySize}
+	if stats := cache.statistics(); stats != expected {
+		t.Fatalf("expected %+v, got %+v", expected, stats)
+	}
+}
+
+func TestCachedQuery(t *testing.T) {
+	dataDir := t.TempDir()
+
+	h, err := New(WithDataDir(dataDir), WithCache(1<<20))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ds, err := h.dataset(ModeSHA1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	query := func() string {
+		t.Helper()
+
+		reader, err := h.Query("00000")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		defer reader.Close()
+
+		data, err := io.ReadAll(reader)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		return string(data)
+	}
+
+	if err := ds.store.Save("00000", "etag", []byte("ABC:1")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Syncs only peek at the cache when checking ETags, the range is not loaded into it
+	if etag, err := ds.store.LoadETag("00000"); err != nil || etag != "etag" {
+		t.Fatalf("unexpected result: %q, %v", etag, err)
+	}
+
+	if stats := h.CacheStats(); stats != (CacheStats{}) {
+		t.Fatalf("expected the cache to be untouched, got %+v", stats)
+	}
+
+	for i := 0; i < 3; i++ {
+		if data := query(); data != "ABC:1" {
+			t.Fatalf("unexpected data: %q", data)
+		}
+	}
+
+	if stats := h.CacheStats(); stats.Hits != 2 || stats.Misses != 1 || stats.Entries != 1 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+
+	// Saving invalidates the cached range
+	if err := ds.store.Save("00000", "etag", []byte("ABC:2")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if data := query(); data != "ABC:2" {
+		t.Fatalf("unexpected data: %q", data)
+	}
+
+	// Ranges replaced by others, e.g., another process, are noticed as well
+	other, err := NewFSStorage(dataDir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := other.Save("00000", "etag", []byte("ABC:3")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if data := query(); data != "ABC:3" {
+		t.Fatalf("unexpected data: %q", data)
+	}
+}
+
+func TestLookupAllocations(t *testing.T) {
+	if raceEnabled {
+		t.Skip("pooled items are dropped at random when running with the race detector")
+	}
+
+	// The remaining allocations stem from opening or checking the range file, i.e., its path and the file itself;
+	// neither decoders, buffers nor locks are allocated anew.
+	for name, test := range map[string]struct {
+		options   []CommonOption
+		maxAllocs float64
+	}{
+		"uncached": {maxAllocs: 6},
+		"cached":   {options: []CommonOption{WithCache(1 << 20)}, maxAllocs: 4},
+	} {
+		test := test
+
+		t.Run(name, func(t *testing.T) {
+			store, err := NewFSStorage(t.TempDir(), test.options...)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if err := store.Save("00000", "etag", []byte("0018A45C4D1DEF81644B54AB7F969B88D65:1\r\n00D4F6E8FA6EECAD2A3AA415EEC418D38EC:2")); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			lookup := func() {
+				if _, found, err := store.(*fsStorage).LookupSuffix("00000", "00D4F6E8FA6EECAD2A3AA415EEC418D38EC"); err != nil || !found {
+					t.Fatalf("unexpected result: %v, %v", found, err)
+				}
+			}
+
+			// Warms up the pools and the cache
+			lookup()
+
+			if allocs := testing.AllocsPerRun(100, lookup); allocs > test.maxAllocs {
+				t.Fatalf("expected at most %v allocations, got %v", test.maxAllocs, allocs)
+			}
+		})
+	}
+}
@@ -0,0 +1,145 @@
+package hibp
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"slices"
+	syncPkg "sync"
+)
+
+// changeLogStorage decorates a storage and records the differences between the previous and the new content of
+// every range that gets saved.
+// The changelog consists of one line per changed hash:
+//
+//	+<hash>:<count>                  hash has been added
+//	-<hash>:<count>                  hash has been removed
+//	~<hash>:<old count>:<new count>  count of the hash has changed
+//
+// Changes are grouped by range; the ranges are written in the order they get saved.
+// The changes of a range are written before it gets saved, so none of them are lost if writing the changelog fails:
+// the range is not saved then, abort is called and every further range fails as well.
+type changeLogStorage struct {
+	Storage
+	lock  syncPkg.Mutex
+	w     io.Writer
+	err   error // the error writing the changelog failed with, guarded by lock
+	abort func(error)
+}
+
+func newChangeLogStorage(inner Storage, w io.Writer, abort func(error)) *changeLogStorage {
+	return &changeLogStorage{
+		Storage: inner,
+		w:       w,
+		abort:   abort,
+	}
+}
+
+func (c *changeLogStorage) Save(key, etag string, data []byte) error {
+	previous, err := c.loadPrevious(key)
+	if err != nil {
+		return fmt.Errorf("loading previous data for changelog: %w", err)
+	}
+
+	if err := c.write(diffRanges(key, previous, data)); err != nil {
+		return err
+	}
+
+	return c.Storage.Save(key, etag, data)
+}
+
+func (c *changeLogStorage) write(changes []byte) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	// Changes written after a failed write would leave a gap in the changelog
+	if c.err != nil {
+		return c.err
+	}
+
+	if len(changes) == 0 {
+		return nil
+	}
+
+	if _, err := c.w.Write(changes); err != nil {
+		c.err = fmt.Errorf("writing changelog: %w", err)
+		c.abort(c.err)
+
+		return c.err
+	}
+
+	return nil
+}
+
+func (c *changeLogStorage) Size(key string) (int64, error) {
+	s, ok := c.Storage.(sizer)
+	if !ok {
+		return 0, errors.New("storage does not support reporting sizes")
+	}
+
+	return s.Size(key)
+}
+
+func (c *changeLogStorage) loadPrevious(key string) ([]byte, error) {
+	reader, err := c.Storage.LoadData(key)
+	if err != nil {
+		// A range that did not exist before is treated like an empty one
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+	defer reader.Close()
+
+	return io.ReadAll(reader)
+}
+
+// diffRanges compares the two versions of a range and returns the changes in the format of the changelog.
+func diffRanges(prefix string, previous, current []byte) []byte {
+	previousCounts := make(map[string]string)
+
+	scanner := bufio.NewScanner(bytes.NewReader(previous))
+	for scanner.Scan() {
+		suffix, count, _ := bytes.Cut(scanner.Bytes(), []byte(":"))
+		previousCounts[string(suffix)] = string(count)
+	}
+
+	var changes bytes.Buffer
+
+	scanner = bufio.NewScanner(bytes.NewReader(current))
+	for scanner.Scan() {
+		suffix, count, _ := bytes.Cut(scanner.Bytes(), []byte(":"))
+		if len(suffix) == 0 {
+			continue
+		}
+
+		previousCount, existed := previousCounts[string(suffix)]
+		delete(previousCounts, string(suffix))
+
+		switch {
+		case !existed:
+			fmt.Fprintf(&changes, "+%s%s:%s\n", prefix, suffix, count)
+		case previousCount != string(count):
+			fmt.Fprintf(&changes, "~%s%s:%s:%s\n", prefix, suffix, previousCount, count)
+		}
+	}
+
+	removed := make([]string, 0, len(previousCounts))
+	for suffix := range previousCounts {
+		if suffix != "" {
+			removed = append(removed, suffix)
+		}
+	}
+
+	slices.Sort(removed)
+
+	for _, suffix := range removed {
+		fmt.Fprintf(&changes, "-%s%s:%s\n", prefix, suffix, previousCounts[suffix])
+	}
+
+	return changes.Bytes()
+}
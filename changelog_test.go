@@ -0,0 +1,133 @@
+package hibp
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	syncPkg "sync"
+	"testing"
+)
+
+func TestChangeLogStorage(t *testing.T) {
+	fsStore := newFSStorage(t.TempDir(), false)
+
+	if err := fsStore.Save("ABCDE", "etag1", []byte("0001:1\r\n0002:2\r\n0003:3")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var changeLog bytes.Buffer
+
+	store := newChangeLogStorage(fsStore, &changeLog, func(error) {})
+
+	if err := store.Save("ABCDE", "etag2", []byte("0000:7\r\n0002:5\r\n0003:3")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// A range that did not exist before consists of additions only
+	if err := store.Save("FFFFF", "etag", []byte("0001:1")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := "+ABCDE0000:7\n~ABCDE0002:2:5\n-ABCDE0001:1\n+FFFFF0001:1\n"
+	if changeLog.String() != expected {
+		t.Fatalf("unexpected changelog: %q", changeLog.String())
+	}
+
+	if etag, err := fsStore.LoadETag("ABCDE"); err != nil || etag != "etag2" {
+		t.Fatalf("range has not been replaced: %q, %v", etag, err)
+	}
+
+	// Saving the same data again does not produce any changes
+	changeLog.Reset()
+
+	if err := store.Save("FFFFF", "etag", []byte("0001:1")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if changeLog.Len() != 0 {
+		t.Fatalf("unexpected changelog: %q", changeLog.String())
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("disk full")
+}
+
+func TestSyncWithChangeLog(t *testing.T) {
+	var (
+		lock   syncPkg.Mutex
+		ranges = map[string]string{
+			"00000": "0001:1",
+			"00001": "0002:2\r\n0003:3",
+		}
+	)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		data := ranges[strings.TrimPrefix(r.URL.Path, "/range/")]
+
+		w.Header().Set("ETag", `"`+data+`"`)
+		_, _ = w.Write([]byte(data))
+	}))
+	defer server.Close()
+
+	h, err := New(WithDataDir(t.TempDir()))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	syncOptions := []SyncOption{SyncWithEndpoint(server.URL + "/range/"), SyncWithLastRange(1), SyncWithMinWorkers(1)}
+
+	var changeLog bytes.Buffer
+
+	if err := h.Sync(append(syncOptions, SyncWithChangeLog(&changeLog))...); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if expected := "+000000001:1\n+000010002:2\n+000010003:3\n"; changeLog.String() != expected {
+		t.Fatalf("unexpected changelog: %q", changeLog.String())
+	}
+
+	lock.Lock()
+	ranges["00001"] = "0002:5"
+	lock.Unlock()
+
+	// Failing to record the changes aborts the sync without replacing the range
+	err = h.Sync(append(syncOptions, SyncWithChangeLog(failingWriter{}))...)
+	if err == nil || !strings.Contains(err.Error(), "writing changelog: disk full") {
+		t.Fatalf("expected the sync to fail writing the changelog, got: %v", err)
+	}
+
+	reader, err := h.Query("00001")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	data, err := io.ReadAll(reader)
+	_ = reader.Close()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if string(data) != "0002:2\r\n0003:3" {
+		t.Fatalf("expected the range to be left as is, got %q", data)
+	}
+
+	// The changes are recorded by the next sync instead of being lost
+	changeLog.Reset()
+
+	if err := h.Sync(append(syncOptions, SyncWithChangeLog(&changeLog))...); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if expected := "~000010002:2:5\n-000010003:3\n"; changeLog.String() != expected {
+		t.Fatalf("unexpected changelog: %q", changeLog.String())
+	}
+}
@@ -1,21 +1,33 @@
 // Package main contains a small utility to export the HIBP data to stdout.
 // Expects the data to be available in the default data directory or in the directory specified as the first argument.
-// Data is expected to be compressed.
+// The format of the data, e.g., whether it is compressed, is detected per range.
+// The hash family can be selected using the "-mode" flag, it defaults to "sha1".
 package main
 
 import (
+	"flag"
 	hibp "github.com/exaring/go-hibp-sync"
 	"os"
 )
 
 func main() {
+	modeFlag := flag.String("mode", hibp.ModeSHA1.String(), "hash family to export, either \"sha1\" or \"ntlm\"")
+	flag.Parse()
+
 	dataDir := hibp.DefaultDataDir
 
-	if len(os.Args) == 2 {
-		dataDir = os.Args[1]
+	if flag.NArg() == 1 {
+		dataDir = flag.Arg(0)
+	}
+
+	mode, err := hibp.ParseHashMode(*modeFlag)
+	if err != nil {
+		_, _ = os.Stderr.WriteString("Invalid mode: " + err.Error())
+
+		os.Exit(1)
 	}
 
-	h, err := hibp.New(hibp.WithDataDir(dataDir))
+	h, err := hibp.New(hibp.WithDataDir(dataDir), hibp.WithHashMode(mode))
 	if err != nil {
 		_, _ = os.Stderr.WriteString("Failed to init HIBP sync: " + err.Error())
 
@@ -0,0 +1,45 @@
+// Package main contains a small utility to import HIBP data from stdin, e.g., to seed hosts without internet access.
+// The input is expected in the format written by the export command or in the format of the former official
+// downloads ("pwned-passwords-sha1-ordered-by-hash-*.txt"), i.e., lines following the schema "<hash>:<count>".
+// The data will be stored in the default data directory or in the directory specified as the first argument,
+// applying zstd compression.
+// The hash family can be selected using the "-mode" flag, it defaults to "sha1".
+package main
+
+import (
+	"bufio"
+	"flag"
+	hibp "github.com/exaring/go-hibp-sync"
+	"os"
+)
+
+func main() {
+	modeFlag := flag.String("mode", hibp.ModeSHA1.String(), "hash family to import, either \"sha1\" or \"ntlm\"")
+	flag.Parse()
+
+	dataDir := hibp.DefaultDataDir
+
+	if flag.NArg() == 1 {
+		dataDir = flag.Arg(0)
+	}
+
+	mode, err := hibp.ParseHashMode(*modeFlag)
+	if err != nil {
+		_, _ = os.Stderr.WriteString("Invalid mode: " + err.Error())
+
+		os.Exit(1)
+	}
+
+	h, err := hibp.New(hibp.WithDataDir(dataDir), hibp.WithHashMode(mode))
+	if err != nil {
+		_, _ = os.Stderr.WriteString("Failed to init HIBP sync: " + err.Error())
+
+		os.Exit(1)
+	}
+
+	if err := h.Import(bufio.NewReaderSize(os.Stdin, 1024*1024)); err != nil {
+		_, _ = os.Stderr.WriteString("Failed to import HIBP data: " + err.Error())
+
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,238 @@
+// Package main contains a small utility to convert the HIBP data in the default data directory or in the directory
+// specified as the first argument into a different format without downloading it again.
+// The target format is selected using the "-format" flag, either "zstd" (default), "plain" or "binary"; the zstd
+// compression level can be set using the "-level" flag.
+// Both hash families are migrated, the NTLM dataset only if it exists.
+// The converted copy is written next to the data directory and verified before both directories are swapped; the
+// previous data is kept in "<data dir>.old".
+// The data directory is locked while migrating, so it cannot be synced at the same time; data directories using
+// snapshots are not supported.
+// The tool keeps track of progress and is able to continue from where it left off in case migrating
+// needs to be interrupted.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	hibp "github.com/exaring/go-hibp-sync"
+	"github.com/k0kubun/go-ansi"
+	"github.com/schollz/progressbar/v3"
+	"io"
+	"io/fs"
+	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+const (
+	migratingSuffix = ".migrating"
+	oldSuffix       = ".old"
+	stateFileName   = ".migrate_state"
+)
+
+// snapshotFiles indicate that a data directory uses snapshots, see hibp.WithSnapshots.
+var snapshotFiles = []string{".current", ".generations"}
+
+// metadataFiles are copied as they are, as they describe the dataset rather than being part of it.
+var metadataFiles = []string{".most_recent_successful_sync", ".failed_ranges"}
+
+func main() {
+	formatFlag := flag.String("format", "zstd", "format to convert the data into, either \"zstd\", \"plain\" or \"binary\"")
+	levelFlag := flag.Int("level", 3, "zstd compression level, only used for the \"zstd\" format")
+	flag.Parse()
+
+	dataDir := hibp.DefaultDataDir
+
+	if flag.NArg() == 1 {
+		dataDir = flag.Arg(0)
+	}
+
+	var options []hibp.CommonOption
+
+	switch *formatFlag {
+	case "zstd":
+		options = append(options, hibp.WithCompressionLevel(*levelFlag))
+	case "plain":
+		options = append(options, hibp.WithoutCompression())
+	case "binary":
+		options = append(options, hibp.WithBinaryFormat())
+	default:
+		_, _ = os.Stderr.WriteString("Invalid format: " + *formatFlag)
+
+		os.Exit(1)
+	}
+
+	if err := run(filepath.Clean(dataDir), options); err != nil {
+		_, _ = os.Stderr.WriteString("Failed to migrate HIBP data: " + err.Error())
+
+		os.Exit(1)
+	}
+}
+
+func run(dataDir string, options []hibp.CommonOption) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if _, err := os.Stat(dataDir); err != nil {
+		return fmt.Errorf("accessing data directory: %w", err)
+	}
+
+	targetDir := dataDir + migratingSuffix
+	oldDir := dataDir + oldSuffix
+
+	if _, err := os.Stat(oldDir); !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("%q already exists, remove it before migrating again", oldDir)
+	}
+
+	modeDirs := map[hibp.HashMode]string{hibp.ModeSHA1: ""}
+	if _, err := os.Stat(path.Join(dataDir, "ntlm")); err == nil {
+		modeDirs[hibp.ModeNTLM] = "ntlm"
+	}
+
+	for _, mode := range []hibp.HashMode{hibp.ModeSHA1, hibp.ModeNTLM} {
+		subDir, exists := modeDirs[mode]
+		if !exists {
+			continue
+		}
+
+		srcDir := path.Join(dataDir, subDir)
+
+		// The ranges of snapshots live in generation directories, migrating the data directory as usual would
+		// find none of them and swap in an empty dataset.
+		for _, name := range snapshotFiles {
+			if _, err := os.Lstat(path.Join(srcDir, name)); err == nil {
+				return fmt.Errorf("%q uses snapshots, which cannot be migrated", srcDir)
+			}
+		}
+
+		// Every dataset has a lock of its own, which is held until the directories have been swapped
+		unlock, err := hibp.LockDataDir(srcDir)
+		if err != nil {
+			return fmt.Errorf("locking %s dataset: %w", mode, err)
+		}
+		defer unlock()
+	}
+
+	for _, mode := range []hibp.HashMode{hibp.ModeSHA1, hibp.ModeNTLM} {
+		subDir, exists := modeDirs[mode]
+		if !exists {
+			continue
+		}
+
+		if err := migrate(ctx, mode, path.Join(dataDir, subDir), path.Join(targetDir, subDir), options); err != nil {
+			return fmt.Errorf("migrating %s dataset: %w", mode, err)
+		}
+	}
+
+	if err := swapDirs(dataDir, targetDir, oldDir); err != nil {
+		return fmt.Errorf("swapping %q and %q: %w", dataDir, targetDir, err)
+	}
+
+	fmt.Printf("Migrated %q, the previous data has been kept in %q\n", dataDir, oldDir)
+
+	return nil
+}
+
+func migrate(ctx context.Context, mode hibp.HashMode, srcDir, dstDir string, options []hibp.CommonOption) error {
+	src, err := hibp.NewFSStorage(srcDir)
+	if err != nil {
+		return fmt.Errorf("opening source: %w", err)
+	}
+
+	dst, err := hibp.NewFSStorage(dstDir, options...)
+	if err != nil {
+		return fmt.Errorf("opening destination: %w", err)
+	}
+
+	if err := os.MkdirAll(dstDir, 0o755); err != nil {
+		return fmt.Errorf("creating destination directory %q: %w", dstDir, err)
+	}
+
+	stateFilePath := path.Join(dstDir, stateFileName)
+
+	stateFile, err := os.OpenFile(stateFilePath, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("opening state file: %w", err)
+	}
+	defer stateFile.Close()
+
+	bar := progressbar.NewOptions(0xFFFFF+1,
+		progressbar.OptionSetWriter(ansi.NewAnsiStdout()),
+		progressbar.OptionEnableColorCodes(true),
+		progressbar.OptionSetDescription(fmt.Sprintf("[cyan]Migrating %s data...[reset]", mode)),
+		progressbar.OptionShowCount(),
+		progressbar.OptionShowIts(),
+		progressbar.OptionSetItsString("prefixes"),
+		progressbar.OptionThrottle(100*time.Millisecond),
+		progressbar.OptionSetPredictTime(false),
+		progressbar.OptionSetElapsedTime(true),
+		progressbar.OptionSetTheme(progressbar.Theme{
+			Saucer:        "[green]=[reset]",
+			SaucerHead:    "[green]>[reset]",
+			SaucerPadding: " ",
+			BarStart:      "[",
+			BarEnd:        "]",
+		}))
+
+	updateProgressBar := func(_, _, _, processed, remaining int64) error {
+		_ = bar.Set64(processed)
+
+		if remaining == 0 {
+			_ = bar.Finish()
+		}
+
+		return nil
+	}
+
+	if err := hibp.Migrate(src, dst,
+		hibp.MigrateWithContext(ctx),
+		hibp.MigrateWithStateFile(stateFile),
+		hibp.MigrateWithProgressFn(updateProgressBar),
+	); err != nil {
+		return fmt.Errorf("%w\nrun again to continue from where it left off", err)
+	}
+
+	for _, name := range metadataFiles {
+		if err := copyFile(path.Join(srcDir, name), path.Join(dstDir, name)); err != nil {
+			return fmt.Errorf("copying %q: %w", name, err)
+		}
+	}
+
+	// Explicitly close the file because otherwise we cannot remove it in the next step
+	stateFile.Close()
+
+	if err := os.Remove(stateFilePath); err != nil {
+		return fmt.Errorf("removing state file %q: %w", stateFilePath, err)
+	}
+
+	return nil
+}
+
+// copyFile copies a file, files that do not exist are skipped.
+func copyFile(srcPath, dstPath string) error {
+	src, err := os.Open(srcPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.Create(dstPath)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(dst, src); err != nil {
+		_ = dst.Close()
+
+		return err
+	}
+
+	return dst.Close()
+}
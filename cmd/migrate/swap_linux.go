@@ -0,0 +1,17 @@
+package main
+
+import (
+	"fmt"
+	"golang.org/x/sys/unix"
+	"os"
+)
+
+// swapDirs exchanges the data directory with the migrated one atomically, i.e., readers observe either the previous or
+// the migrated data at any time; the previous data ends up in oldDir afterward.
+func swapDirs(dataDir, targetDir, oldDir string) error {
+	if err := unix.Renameat2(unix.AT_FDCWD, targetDir, unix.AT_FDCWD, dataDir, unix.RENAME_EXCHANGE); err != nil {
+		return fmt.Errorf("exchanging directories: %w", err)
+	}
+
+	return os.Rename(targetDir, oldDir)
+}
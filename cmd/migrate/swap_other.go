@@ -0,0 +1,15 @@
+//go:build !linux
+
+package main
+
+import "os"
+
+// swapDirs replaces the data directory with the migrated one, the previous data ends up in oldDir afterward.
+// Without support for exchanging directories atomically, the data directory is missing for a short moment.
+func swapDirs(dataDir, targetDir, oldDir string) error {
+	if err := os.Rename(dataDir, oldDir); err != nil {
+		return err
+	}
+
+	return os.Rename(targetDir, dataDir)
+}
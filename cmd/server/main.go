@@ -0,0 +1,69 @@
+// Package main contains a small server that serves the HIBP data the same way the official Pwned Passwords API does,
+// i.e., "GET /range/{prefix}".
+// Expects the data to be available in the default data directory or in the directory specified as the first argument.
+// The format of the data, e.g., whether it is compressed, is detected per range.
+// The data directory is opened read-only, it can be kept in sync by running the "sync" command separately.
+// The address to listen on can be changed using the "-listen" flag, it defaults to ":8080".
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	hibp "github.com/exaring/go-hibp-sync"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+func main() {
+	listenAddr := flag.String("listen", ":8080", "address to listen on")
+	flag.Parse()
+
+	dataDir := hibp.DefaultDataDir
+
+	if flag.NArg() == 1 {
+		dataDir = flag.Arg(0)
+	}
+
+	if err := run(dataDir, *listenAddr); err != nil {
+		_, _ = os.Stderr.WriteString("Failed to serve HIBP data: " + err.Error())
+
+		os.Exit(1)
+	}
+}
+
+func run(dataDir, listenAddr string) error {
+	// The data dir is only read, it may be kept in sync by another process
+	h, err := hibp.New(hibp.WithDataDir(dataDir), hibp.WithReadOnly())
+	if err != nil {
+		return fmt.Errorf("initialising HIBP sync: %w", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	server := &http.Server{
+		Addr:              listenAddr,
+		Handler:           h.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		_ = server.Shutdown(shutdownCtx)
+	}()
+
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("listening on %q: %w", listenAddr, err)
+	}
+
+	return nil
+}
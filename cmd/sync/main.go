@@ -3,9 +3,15 @@
 // The data will be stored applying zstd compression.
 // The tool keeps track of progress and is able to continue from where it left off in case syncing
 // needs to be interrupted.
+// The hash family can be selected using the "-mode" flag, it defaults to "sha1".
+// Ranges that failed permanently during previous runs can be re-requested using the "-retry-failed" flag.
+// The changes of the run can be recorded to a file using the "-changelog" flag.
 package main
 
 import (
+	"bufio"
+	"errors"
+	"flag"
 	"fmt"
 	hibp "github.com/exaring/go-hibp-sync"
 	"github.com/k0kubun/go-ansi"
@@ -16,31 +22,32 @@ import (
 )
 
 func main() {
+	modeFlag := flag.String("mode", hibp.ModeSHA1.String(), "hash family to sync, either \"sha1\" or \"ntlm\"")
+	retryFailedFlag := flag.Bool("retry-failed", false, "only re-request the ranges that failed during previous runs")
+	changeLogFlag := flag.String("changelog", "", "file to record added, removed and changed hashes to")
+	flag.Parse()
+
 	dataDir := hibp.DefaultDataDir
 
-	if len(os.Args) == 2 {
-		dataDir = os.Args[1]
+	if flag.NArg() == 1 {
+		dataDir = flag.Arg(0)
 	}
 
-	if err := run(dataDir); err != nil {
-		_, _ = os.Stderr.WriteString("Failed to sync HIBP data: " + err.Error())
+	mode, err := hibp.ParseHashMode(*modeFlag)
+	if err != nil {
+		_, _ = os.Stderr.WriteString("Invalid mode: " + err.Error())
 
 		os.Exit(1)
 	}
-}
 
-func run(dataDir string) error {
-	stateFilePath := path.Join(dataDir, hibp.DefaultStateFileName)
-	if err := os.MkdirAll(path.Dir(stateFilePath), 0o755); err != nil {
-		return fmt.Errorf("creating state file directory %q: %w", stateFilePath, err)
-	}
+	if err := run(dataDir, mode, *retryFailedFlag, *changeLogFlag); err != nil {
+		_, _ = os.Stderr.WriteString("Failed to sync HIBP data: " + err.Error())
 
-	stateFile, err := os.OpenFile(stateFilePath, os.O_RDWR|os.O_CREATE, 0644)
-	if err != nil {
-		return fmt.Errorf("opening state file: %w", err)
+		os.Exit(1)
 	}
-	defer stateFile.Close()
+}
 
+func run(dataDir string, mode hibp.HashMode, retryFailed bool, changeLogPath string) error {
 	bar := progressbar.NewOptions(0xFFFFF+1,
 		progressbar.OptionSetWriter(ansi.NewAnsiStdout()),
 		progressbar.OptionEnableColorCodes(true),
@@ -69,14 +76,75 @@ func run(dataDir string) error {
 		return nil
 	}
 
-	h, err := hibp.New(hibp.WithDataDir(dataDir))
+	h, err := hibp.New(hibp.WithDataDir(dataDir), hibp.WithHashMode(mode))
 	if err != nil {
 		return fmt.Errorf("initialising HIBP sync: %w", err)
 	}
 
-	if err := h.Sync(
+	syncOptions := []hibp.SyncOption{
 		hibp.SyncWithProgressFn(updateProgressBar),
-		hibp.SyncWithStateFile(stateFile)); err != nil {
+		hibp.SyncWithResultFn(printResult),
+	}
+
+	if changeLogPath != "" {
+		changeLogFile, err := os.Create(changeLogPath)
+		if err != nil {
+			return fmt.Errorf("creating changelog %q: %w", changeLogPath, err)
+		}
+		defer changeLogFile.Close()
+
+		changeLog := bufio.NewWriter(changeLogFile)
+		defer changeLog.Flush()
+
+		syncOptions = append(syncOptions, hibp.SyncWithChangeLog(changeLog))
+	}
+
+	stateFileName := hibp.DefaultStateFileName
+	if mode != hibp.ModeSHA1 {
+		// Each hash family needs its own state file as they can be synced independently.
+		stateFileName += "-" + mode.String()
+	}
+
+	stateFilePath := path.Join(dataDir, stateFileName)
+
+	if retryFailed {
+		previousSync := h.MostRecentSuccessfulSyncOf(mode)
+
+		if err := h.RetryFailed(syncOptions...); err != nil {
+			return fmt.Errorf("retrying failed ranges: %w", err)
+		}
+
+		// Unless the retry completed the dataset, e.g., because the previous run has been interrupted, the next
+		// regular run has to continue from the state file.
+		if !h.MostRecentSuccessfulSyncOf(mode).After(previousSync) {
+			return nil
+		}
+
+		// The state file of the failed run marks all other ranges as done, continuing from it would skip them
+		// during the next regular run.
+		if err := os.Remove(stateFilePath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("removing state file %q: %w", stateFilePath, err)
+		}
+
+		return nil
+	}
+
+	if err := os.MkdirAll(path.Dir(stateFilePath), 0o755); err != nil {
+		return fmt.Errorf("creating state file directory %q: %w", stateFilePath, err)
+	}
+
+	stateFile, err := os.OpenFile(stateFilePath, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("opening state file: %w", err)
+	}
+	defer stateFile.Close()
+
+	if err := h.Sync(append(syncOptions, hibp.SyncWithStateFile(stateFile))...); err != nil {
+		var syncErr *hibp.SyncError
+		if errors.As(err, &syncErr) {
+			return fmt.Errorf("syncing: %w\nrun again with -retry-failed to re-request only the failed ranges", err)
+		}
+
 		return fmt.Errorf("syncing: %w", err)
 	}
 
@@ -89,3 +157,8 @@ func run(dataDir string) error {
 
 	return nil
 }
+
+func printResult(result hibp.SyncResult) {
+	fmt.Printf("\nUpdated %d, not modified %d, failed %d ranges; downloaded %d bytes, wrote %d bytes in %s\n",
+		result.Updated, result.NotModified, result.Failed, result.BytesDownloaded, result.BytesWritten, result.Duration.Round(time.Second))
+}
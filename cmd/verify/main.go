@@ -0,0 +1,95 @@
+// Package main contains a small utility to verify the integrity of the HIBP data, similar to fsck.
+// Expects the data to be available in the default data directory or in the directory specified as the first argument.
+// The format of the data, e.g., whether it is compressed, is detected per range.
+// The hash family can be selected using the "-mode" flag, it defaults to "sha1".
+// Problems are repaired, i.e., broken ranges are fetched again, when the "-repair" flag is set.
+// The command exits with a non-zero code if the dataset is incomplete or malformed.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	hibp "github.com/exaring/go-hibp-sync"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+func main() {
+	modeFlag := flag.String("mode", hibp.ModeSHA1.String(), "hash family to verify, either \"sha1\" or \"ntlm\"")
+	repairFlag := flag.Bool("repair", false, "remove temporary files and fetch missing or corrupt ranges again")
+	flag.Parse()
+
+	dataDir := hibp.DefaultDataDir
+
+	if flag.NArg() == 1 {
+		dataDir = flag.Arg(0)
+	}
+
+	mode, err := hibp.ParseHashMode(*modeFlag)
+	if err != nil {
+		_, _ = os.Stderr.WriteString("Invalid mode: " + err.Error())
+
+		os.Exit(1)
+	}
+
+	ok, err := run(dataDir, mode, *repairFlag)
+	if err != nil {
+		_, _ = os.Stderr.WriteString("Failed to verify HIBP data: " + err.Error())
+
+		os.Exit(1)
+	}
+
+	if !ok {
+		os.Exit(2)
+	}
+}
+
+func run(dataDir string, mode hibp.HashMode, repair bool) (bool, error) {
+	h, err := hibp.New(hibp.WithDataDir(dataDir), hibp.WithHashMode(mode))
+	if err != nil {
+		return false, fmt.Errorf("initialising HIBP sync: %w", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var options []hibp.VerifyOption
+	if repair {
+		options = append(options, hibp.VerifyWithRepair())
+	}
+
+	report, err := h.Verify(ctx, options...)
+	if report != nil {
+		printReport(report)
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	return report.OK(), nil
+}
+
+func printReport(report *hibp.VerifyReport) {
+	for _, prefix := range report.Missing {
+		fmt.Printf("missing: %s\n", prefix)
+	}
+
+	for _, corrupt := range report.Corrupt {
+		fmt.Printf("corrupt: %s: %v\n", corrupt.Prefix, corrupt.Err)
+	}
+
+	for _, leftover := range report.Leftovers {
+		fmt.Printf("leftover: %s\n", leftover)
+	}
+
+	fmt.Printf("Checked %d ranges: %d missing, %d corrupt, %d leftovers", report.Checked, len(report.Missing), len(report.Corrupt), len(report.Leftovers))
+
+	if report.Repaired {
+		fmt.Print(" - all repaired")
+	}
+
+	fmt.Println()
+}
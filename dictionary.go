@@ -0,0 +1,187 @@
+package hibp
+
+import (
+	"errors"
+	"fmt"
+	"github.com/alitto/pond"
+	"github.com/klauspost/compress/dict"
+	"github.com/klauspost/compress/zstd"
+	"io"
+	"math/rand"
+	"os"
+	"path"
+	syncPkg "sync"
+)
+
+const (
+	defaultDictionarySamples = 2000
+	// defaultDictionaryMaxSize matches the default of the zstd command line tool.
+	defaultDictionaryMaxSize = 112640
+	minDictionarySamples     = 10
+)
+
+// TrainDictionary trains a zstd dictionary on a sample of the local ranges and stores it in the data dir.
+// Ranges are small and very similar to each other, so a shared dictionary improves the compression ratio
+// considerably.
+// The dictionary is used for all ranges written from now on, also by instances created later on; reading ranges
+// picks the dictionary up transparently, also in instances existing already, e.g., of other processes sharing the data
+// dir, which reload it once they come across a range compressed with it.
+// Existing ranges are only rewritten if requested using TrainWithRecompression.
+// Training requires the file-based storage with compression enabled and fails if a dictionary exists already, as
+// ranges compressed with it would become unreadable when it got replaced.
+func (h *HIBP) TrainDictionary(options ...TrainOption) (err error) {
+	if h.readOnly {
+		return ErrReadOnly
+	}
+
+	config := &trainConfig{
+		mode:       h.mode,
+		samples:    defaultDictionarySamples,
+		maxSize:    defaultDictionaryMaxSize,
+		minWorkers: defaultWorkers,
+	}
+
+	for _, option := range options {
+		option(config)
+	}
+
+	ds, err := h.dataset(config.mode)
+	if err != nil {
+		return err
+	}
+
+	store, ok := ds.store.(*fsStorage)
+	if !ok || !store.useCompression() {
+		return errors.New("dictionaries are only supported by the file-based storage with compression enabled")
+	}
+
+	unlock, err := ds.lock(true)
+	if err != nil {
+		return err
+	}
+	defer releaseLock(unlock, &err)
+
+	dictPath := path.Join(ds.dataDir, hibpDictionaryPath)
+
+	if _, err := os.Stat(dictPath); err == nil {
+		return fmt.Errorf("dictionary %q exists already", dictPath)
+	}
+
+	keys, err := store.rangeKeys()
+	if err != nil {
+		return err
+	}
+
+	samples, err := sampleRanges(store, keys, config.samples)
+	if err != nil {
+		return err
+	}
+
+	if len(samples) < minDictionarySamples {
+		return fmt.Errorf("only %d ranges to train the dictionary on, at least %d are required", len(samples), minDictionarySamples)
+	}
+
+	codec := store.codec.Load()
+
+	level := zstd.SpeedDefault
+	if codec.level != 0 {
+		level = zstd.EncoderLevelFromZstd(codec.level)
+	}
+
+	d, err := dict.BuildZstdDict(samples, dict.Options{
+		MaxDictSize: config.maxSize,
+		HashBytes:   6,
+		// IDs below 32768 are reserved by the zstd format
+		ZstdDictID: 32768 + uint32(rand.Int31()),
+		ZstdLevel:  level,
+	})
+	if err != nil {
+		return fmt.Errorf("building dictionary: %w", err)
+	}
+
+	if err := os.WriteFile(dictPath+tmpSuffix, d, 0o644); err != nil {
+		return fmt.Errorf("writing dictionary: %w", err)
+	}
+
+	if err := os.Rename(dictPath+tmpSuffix, dictPath); err != nil {
+		return fmt.Errorf("renaming dictionary: %w", err)
+	}
+
+	if err := store.configureCodec(codec.level, d); err != nil {
+		return err
+	}
+
+	if !config.recompress {
+		return nil
+	}
+
+	return recompressRanges(store, keys, config.minWorkers)
+}
+
+// sampleRanges loads the data of up to n of the given ranges, spread evenly across them.
+func sampleRanges(store Storage, keys []string, n int) ([][]byte, error) {
+	var samples [][]byte
+
+	for i := 0; i < min(n, len(keys)); i++ {
+		rangePrefix := keys[i*len(keys)/min(n, len(keys))]
+
+		data, err := loadRangeData(store, rangePrefix)
+		if err != nil {
+			return nil, fmt.Errorf("loading range %q: %w", rangePrefix, err)
+		}
+
+		samples = append(samples, data)
+	}
+
+	return samples, nil
+}
+
+// recompressRanges rewrites the given ranges of the storage, applying its current codec.
+func recompressRanges(store *fsStorage, keys []string, minWorkers int) error {
+	var (
+		mErr    error
+		errLock syncPkg.Mutex
+	)
+
+	pool := pond.New(minWorkers, 0, pond.MinWorkers(minWorkers))
+
+	for _, rangePrefix := range keys {
+		rangePrefix := rangePrefix
+
+		pool.Submit(func() {
+			err := func() error {
+				etag, err := store.LoadETag(rangePrefix)
+				if err != nil {
+					return err
+				}
+
+				data, err := loadRangeData(store, rangePrefix)
+				if err != nil {
+					return err
+				}
+
+				return store.Save(rangePrefix, etag, data)
+			}()
+			if err != nil {
+				errLock.Lock()
+				defer errLock.Unlock()
+
+				mErr = errors.Join(mErr, fmt.Errorf("recompressing range %q: %w", rangePrefix, err))
+			}
+		})
+	}
+
+	pool.StopAndWait()
+
+	return mErr
+}
+
+func loadRangeData(store Storage, rangePrefix string) ([]byte, error) {
+	reader, err := store.LoadData(rangePrefix)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	return io.ReadAll(reader)
+}
@@ -0,0 +1,110 @@
+package hibp
+
+import (
+	"fmt"
+	"github.com/klauspost/compress/zstd"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTrainDictionary(t *testing.T) {
+	dataDir := t.TempDir()
+
+	h, err := New(WithDataDir(dataDir))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	store := h.datasets[ModeSHA1].store
+
+	ranges := make(map[string]string)
+	rnd := rand.New(rand.NewSource(1))
+
+	for r := int64(0); r < numRanges; r += numRanges / 100 {
+		var lines []string
+		for i := 0; i < 50; i++ {
+			lines = append(lines, fmt.Sprintf("%02X%033X:%d", i*5, rnd.Int63(), rnd.Intn(100)+1))
+		}
+
+		rangePrefix := toRangeString(r)
+		ranges[rangePrefix] = strings.Join(lines, "\r\n")
+
+		if err := store.Save(rangePrefix, "etag", []byte(ranges[rangePrefix])); err != nil {
+			t.Fatalf("saving range: %v", err)
+		}
+	}
+
+	// Instances existing already, e.g., of other processes, pick up the dictionary once they come across it
+	existing, err := New(WithDataDir(dataDir), WithReadOnly())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := h.TrainDictionary(TrainWithSamples(50), TrainWithRecompression(), TrainWithMinWorkers(4)); err != nil {
+		t.Fatalf("training dictionary: %v", err)
+	}
+
+	dict, err := os.ReadFile(filepath.Join(dataDir, hibpDictionaryPath))
+	if err != nil {
+		t.Fatalf("expected dictionary in data dir: %v", err)
+	}
+
+	dictInfo, err := zstd.InspectDictionary(dict)
+	if err != nil {
+		t.Fatalf("inspecting dictionary: %v", err)
+	}
+
+	// All ranges have been recompressed using the dictionary
+	for rangePrefix := range ranges {
+		file, err := os.ReadFile(store.(*fsStorage).filePath(rangePrefix))
+		if err != nil {
+			t.Fatalf("reading range %q: %v", rangePrefix, err)
+		}
+
+		var header zstd.Header
+		if err := header.Decode(file[rangeFileHeaderSize:]); err != nil {
+			t.Fatalf("decoding frame header of range %q: %v", rangePrefix, err)
+		}
+
+		if header.DictionaryID != dictInfo.ID() {
+			t.Fatalf("expected range %q to be compressed using dictionary %d, got %d", rangePrefix, dictInfo.ID(), header.DictionaryID)
+		}
+	}
+
+	if err := h.TrainDictionary(); err == nil {
+		t.Fatal("expected an error training a dictionary once more")
+	}
+
+	// New instances pick up the dictionary
+	h, err = New(WithDataDir(dataDir))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, instance := range []*HIBP{h, existing} {
+		for rangePrefix, expected := range ranges {
+			data, err := loadRangeData(instance.datasets[ModeSHA1].store, rangePrefix)
+			if err != nil {
+				t.Fatalf("loading range %q: %v", rangePrefix, err)
+			}
+
+			if string(data) != expected {
+				t.Fatalf("unexpected data of range %q", rangePrefix)
+			}
+		}
+	}
+}
+
+func TestTrainDictionaryRequiresCompression(t *testing.T) {
+	h, err := New(WithDataDir(t.TempDir()), WithoutCompression())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := h.TrainDictionary(); err == nil {
+		t.Fatal("expected an error training a dictionary without compression")
+	}
+}
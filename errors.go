@@ -0,0 +1,86 @@
+package hibp
+
+import (
+	"fmt"
+	"strings"
+)
+
+// RangeError describes why a single range could not be processed.
+type RangeError struct {
+	Prefix string
+	Err    error
+}
+
+func (e *RangeError) Error() string {
+	return fmt.Sprintf("processing range %q: %v", e.Prefix, e.Err)
+}
+
+func (e *RangeError) Unwrap() error {
+	return e.Err
+}
+
+// SyncError is returned by Sync if one or more ranges could not be synced.
+// All other ranges have been processed nonetheless; the failed ones can be retried using SyncWithRanges or
+// HIBP.RetryFailed.
+type SyncError struct {
+	// Failures lists the ranges that failed, ordered by their prefix.
+	Failures []*RangeError
+}
+
+func (e *SyncError) Error() string {
+	return describeFailures(e.Failures)
+}
+
+func (e *SyncError) Unwrap() []error {
+	return unwrapFailures(e.Failures)
+}
+
+// Prefixes returns the prefixes of the failed ranges, ready to be passed to SyncWithRanges.
+func (e *SyncError) Prefixes() []string {
+	prefixes := make([]string, 0, len(e.Failures))
+	for _, failure := range e.Failures {
+		prefixes = append(prefixes, failure.Prefix)
+	}
+
+	return prefixes
+}
+
+// MigrateError is returned by Migrate if one or more ranges could not be copied or did not pass the verification.
+// All other ranges have been processed nonetheless; running the migration again using the same state file only
+// processes the failed ones.
+type MigrateError struct {
+	// Failures lists the ranges that failed, ordered by their prefix.
+	Failures []*RangeError
+}
+
+func (e *MigrateError) Error() string {
+	return describeFailures(e.Failures)
+}
+
+func (e *MigrateError) Unwrap() []error {
+	return unwrapFailures(e.Failures)
+}
+
+func describeFailures(failures []*RangeError) string {
+	const maxListed = 10
+
+	msgs := make([]string, 0, min(len(failures), maxListed))
+	for _, failure := range failures[:min(len(failures), maxListed)] {
+		msgs = append(msgs, failure.Error())
+	}
+
+	if len(failures) > maxListed {
+		msgs = append(msgs, fmt.Sprintf("and %d more", len(failures)-maxListed))
+	}
+
+	return fmt.Sprintf("%d ranges failed: %s", len(failures), strings.Join(msgs, "; "))
+}
+
+func unwrapFailures(failures []*RangeError) []error {
+	errs := make([]error, 0, len(failures))
+	for _, failure := range failures {
+		errs = append(errs, failure)
+	}
+
+	return errs
+}
@@ -11,7 +11,7 @@ import (
 // although it does not feel right.
 var lineSeparator = []byte("\r\n")
 
-func export(from, to int64, store storage, w io.Writer) error {
+func export(from, to int64, store Storage, w io.Writer) error {
 	for i := from; i < to; i++ {
 		err := func() error {
 			rangePrefix := toRangeString(i)
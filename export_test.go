@@ -10,7 +10,7 @@ import (
 
 func TestExport(t *testing.T) {
 	ctrl := gomock.NewController(t)
-	storageMock := NewMockstorage(ctrl)
+	storageMock := NewMockStorage(ctrl)
 
 	storageMock.EXPECT().LoadData("00000").Return(io.NopCloser(bytes.NewReader([]byte("suffix:counter11\r\nsuffix:counter12"))), nil)
 	storageMock.EXPECT().LoadData("00001").Return(io.NopCloser(bytes.NewReader([]byte("suffix:counter2"))), nil)
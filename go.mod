@@ -11,6 +11,7 @@ require (
 	github.com/klauspost/compress v1.17.6
 	github.com/schollz/progressbar/v3 v3.14.1
 	go.uber.org/mock v0.4.0
+	golang.org/x/sys v0.17.0
 )
 
 require (
@@ -19,6 +20,6 @@ require (
 	github.com/mattn/go-isatty v0.0.20 // indirect
 	github.com/mitchellh/colorstring v0.0.0-20190213212951-d06e56a500db // indirect
 	github.com/rivo/uniseg v0.4.7 // indirect
-	golang.org/x/sys v0.17.0 // indirect
+	github.com/stretchr/testify v1.8.1 // indirect
 	golang.org/x/term v0.17.0 // indirect
 )
@@ -0,0 +1,155 @@
+package hibp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/alitto/pond"
+	"io"
+	"strconv"
+	syncPkg "sync"
+)
+
+// importETag is stored for imported ranges.
+// As it is empty, the next sync will not send an "If-None-Match" header and will therefore refresh every range.
+const importETag = ""
+
+// Import reads a dataset in the format written by Export, i.e., lines following the schema "<hash>:<count>" ordered
+// by hash, and stores it range by range.
+// This is also the format of the former official downloads ("pwned-passwords-sha1-ordered-by-hash-*.txt"), and it
+// allows seeding the local copy without talking to the upstream API.
+// Imported ranges do not carry an ETag, the next sync will therefore refresh all of them.
+// Ranges not contained in the input are left untouched.
+func (h *HIBP) Import(r io.Reader, options ...ImportOption) (err error) {
+	if h.readOnly {
+		return ErrReadOnly
+	}
+
+	config := &importConfig{
+		ctx:        context.Background(),
+		mode:       h.mode,
+		minWorkers: defaultWorkers,
+	}
+
+	for _, option := range options {
+		option(config)
+	}
+
+	ds, err := h.dataset(config.mode)
+	if err != nil {
+		return err
+	}
+
+	unlock, err := ds.lock(true)
+	if err != nil {
+		return err
+	}
+	defer releaseLock(unlock, &err)
+
+	var (
+		mErr    error
+		errLock syncPkg.Mutex
+	)
+
+	// Same as for syncing, the pool must not buffer tasks, otherwise the whole input would end up in memory.
+	pool := pond.New(config.minWorkers, 0, pond.MinWorkers(config.minWorkers))
+
+	save := func(rangePrefix string, data []byte) {
+		pool.Submit(func() {
+			if err := ds.store.Save(rangePrefix, importETag, data); err != nil {
+				errLock.Lock()
+				defer errLock.Unlock()
+
+				mErr = errors.Join(mErr, fmt.Errorf("saving range %q: %w", rangePrefix, err))
+			}
+		})
+	}
+
+	readErr := importRanges(r, config.mode, func(rangePrefix string, data []byte) error {
+		if err := config.ctx.Err(); err != nil {
+			return err
+		}
+
+		save(rangePrefix, data)
+
+		return nil
+	})
+
+	pool.StopAndWait()
+
+	return errors.Join(readErr, mErr)
+}
+
+// importRanges splits the input into ranges and calls onRange for each of them in ascending order.
+// The data passed to onRange follows the format of the upstream API.
+func importRanges(r io.Reader, mode HashMode, onRange func(rangePrefix string, data []byte) error) error {
+	var (
+		currentPrefix string
+		previousHash  []byte
+		data          []byte
+		lineNumber    int
+	)
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		lineNumber++
+
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		hash, count, found := bytes.Cut(line, []byte(":"))
+		if !found || len(hash) != mode.hashLength() {
+			return fmt.Errorf("line %d: expected \"<%s hash>:<count>\", got %q", lineNumber, mode, line)
+		}
+
+		hash = bytes.ToUpper(hash)
+
+		if _, err := hex.Decode(make([]byte, len(hash)/2), hash); err != nil {
+			return fmt.Errorf("line %d: invalid hash %q: %w", lineNumber, hash, err)
+		}
+
+		if _, err := strconv.ParseUint(string(count), 10, 64); err != nil {
+			return fmt.Errorf("line %d: invalid count %q: %w", lineNumber, count, err)
+		}
+
+		if bytes.Compare(hash, previousHash) <= 0 {
+			return fmt.Errorf("line %d: input is not ordered by hash, %q follows %q", lineNumber, hash, previousHash)
+		}
+
+		previousHash = append(previousHash[:0], hash...)
+
+		rangePrefix := string(hash[:prefixLength])
+
+		if rangePrefix != currentPrefix {
+			if currentPrefix != "" {
+				if err := onRange(currentPrefix, data); err != nil {
+					return err
+				}
+			}
+
+			currentPrefix = rangePrefix
+			data = nil
+		} else {
+			data = append(data, lineSeparator...)
+		}
+
+		data = append(data, hash[prefixLength:]...)
+		data = append(data, ':')
+		data = append(data, count...)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("reading input: %w", err)
+	}
+
+	if currentPrefix != "" {
+		return onRange(currentPrefix, data)
+	}
+
+	return nil
+}
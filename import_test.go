@@ -0,0 +1,82 @@
+package hibp
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestImport(t *testing.T) {
+	const dump = "000000005AD76BD555C1D6D771DE417A4B87E4B4:10\r\n" +
+		"00000000A8DAE4228F821FB418F59826079BF368:4\r\n" +
+		"00001006D9E0A5BA7D6CF1F7CE6FC1C4B0C8D8F2:2\r\n" +
+		"00002C2E3C9A6F8BBF5B9B5C8FD1DB7D3A4AB1F1:1\r\n"
+
+	h, err := New(WithDataDir(t.TempDir()))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The old official downloads have been lower-cased at times
+	if err := h.Import(strings.NewReader(strings.ToLower(dump)), ImportWithMinWorkers(2)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	reader, err := h.Query("00000")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	data, err := io.ReadAll(reader)
+	_ = reader.Close()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if string(data) != "0005AD76BD555C1D6D771DE417A4B87E4B4:10\r\n000A8DAE4228F821FB418F59826079BF368:4" {
+		t.Fatalf("unexpected range: %q", data)
+	}
+
+	etag, err := h.datasets[ModeSHA1].store.LoadETag("00000")
+	if err != nil || etag != importETag {
+		t.Fatalf("unexpected etag %q: %v", etag, err)
+	}
+
+	// Importing and exporting are inverse operations
+	var exported bytes.Buffer
+
+	if err := export(0, 3, h.datasets[ModeSHA1].store, &exported); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if exported.String() != strings.TrimSuffix(dump, "\r\n") {
+		t.Fatalf("unexpected export: %q", exported.String())
+	}
+}
+
+func TestImportRejectsInvalidInput(t *testing.T) {
+	h, err := New(WithDataDir(t.TempDir()))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for name, input := range map[string]string{
+		"unordered":      "00001006D9E0A5BA7D6CF1F7CE6FC1C4B0C8D8F2:2\n000000005AD76BD555C1D6D771DE417A4B87E4B4:10",
+		"duplicate":      "000000005AD76BD555C1D6D771DE417A4B87E4B4:10\n000000005AD76BD555C1D6D771DE417A4B87E4B4:10",
+		"missing count":  "000000005AD76BD555C1D6D771DE417A4B87E4B4",
+		"invalid count":  "000000005AD76BD555C1D6D771DE417A4B87E4B4:x",
+		"invalid hash":   "X00000005AD76BD555C1D6D771DE417A4B87E4B4:10",
+		"wrong mode":     "00000000A8DAE4228F821FB418F59826:4",
+		"truncated hash": "000000005AD76BD555C1D6D771DE417A4B87E4B:10",
+	} {
+		if err := h.Import(strings.NewReader(input)); err == nil {
+			t.Fatalf("expected an error for %s input", name)
+		}
+	}
+
+	// NTLM hashes are shorter
+	if err := h.Import(strings.NewReader("00000000A8DAE4228F821FB418F59826:4"), ImportWithMode(ModeNTLM)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
@@ -10,7 +10,10 @@ import (
 	"io"
 	"os"
 	"path"
+	"slices"
 	"strconv"
+	"strings"
+	syncPkg "sync"
 	"sync/atomic"
 	"time"
 )
@@ -22,85 +25,278 @@ const (
 	defaultWorkers                   = 50
 	defaultLastRange                 = 0xFFFFF
 	hibpMostRecentSuccessfulSyncPath = ".most_recent_successful_sync"
+	hibpFailedRangesPath             = ".failed_ranges"
+	hibpDictionaryPath               = ".zstd_dictionary"
 )
 
 // HIBP bundles the functionality of the HIBP package.
 // In order to allow concurrent operations on the local, file-based dataset efficiently and safely, a shared set of
 // locks is required - this gets managed by the HIBP type.
 type HIBP struct {
-	store                    storage
+	datasets map[HashMode]*dataset
+	mode     HashMode
+	readOnly bool
+	cache    *rangeCache // nil if caching is disabled
+}
+
+// ErrReadOnly is returned by operations modifying the local dataset, e.g., Sync, if the instance has been created
+// using WithReadOnly.
+var ErrReadOnly = errors.New("the dataset is opened read-only")
+
+// dataset bundles everything related to the local copy of one hash family.
+type dataset struct {
+	store                    Storage
 	dataDir                  string
+	sharedLocks              bool
+	readOnly                 bool
 	mostRecentSuccessfulSync atomic.Pointer[time.Time]
+	// timestampLock guards re-reading the timestamp of the most recent successful sync in read-only mode, which is
+	// skipped as long as the modification time of the file, timestampModTime, does not change.
+	// The file is checked at most once per timestampRefreshInterval, timestampCheckedAt holds the point in time
+	// (in Unix nanoseconds) of the most recent check.
+	timestampLock      syncPkg.Mutex
+	timestampModTime   time.Time
+	timestampCheckedAt atomic.Int64
 }
 
+// timestampRefreshInterval limits how often the timestamp of the most recent successful sync is checked for changes
+// in read-only mode, as it is queried for every request served by the handler.
+const timestampRefreshInterval = time.Second
+
 func New(options ...CommonOption) (*HIBP, error) {
 	config := commonConfig{
 		dataDir:       DefaultDataDir,
 		noCompression: false,
+		mode:          ModeSHA1,
 	}
 
 	for _, option := range options {
 		option(&config)
 	}
 
-	storage := newFSStorage(config.dataDir, config.noCompression)
+	if !config.mode.valid() {
+		return nil, fmt.Errorf("unsupported hash mode %v", config.mode)
+	}
+
+	h := &HIBP{
+		datasets: make(map[HashMode]*dataset, len(hashModes)),
+		mode:     config.mode,
+		readOnly: config.readOnly,
+	}
+
+	if config.cacheSize > 0 {
+		h.cache = newRangeCache(config.cacheSize)
+	}
+
+	if config.storage != nil {
+		if config.modeStorages == nil {
+			config.modeStorages = make(map[HashMode]Storage)
+		}
+
+		config.modeStorages[config.mode] = config.storage
+	}
+
+	for _, mode := range hashModes {
+		dataDir := mode.dataDir(config.dataDir)
+
+		store, exists := config.modeStorages[mode]
+		switch {
+		case exists:
+		case config.snapshots:
+			snapshotStore, err := newSnapshotStorage(dataDir, config)
+			if err != nil {
+				return nil, fmt.Errorf("initialising %s storage: %w", mode, err)
+			}
 
-	var mostRecentSuccessfulSync time.Time
+			snapshotStore.cache = h.cache
+			store = snapshotStore
+		default:
+			fsStore, err := newFSStorageFromConfig(dataDir, config)
+			if err != nil {
+				return nil, fmt.Errorf("initialising %s storage: %w", mode, err)
+			}
+
+			fsStore.cache = h.cache
+			store = fsStore
+		}
+
+		ds, err := newDataset(dataDir, store, config)
+		if err != nil {
+			return nil, fmt.Errorf("initialising %s dataset: %w", mode, err)
+		}
+
+		h.datasets[mode] = ds
+	}
+
+	return h, nil
+}
+
+func newDataset(dataDir string, store Storage, config commonConfig) (*dataset, error) {
+	mostRecentSuccessfulSync, err := readMostRecentSuccessfulSync(dataDir)
+	if err != nil {
+		return nil, err
+	}
+
+	ds := &dataset{
+		store:       store,
+		dataDir:     dataDir,
+		sharedLocks: config.sharedLocks,
+		readOnly:    config.readOnly,
+	}
 
-	mostRecentSuccessfulSyncPath := path.Join(config.dataDir, hibpMostRecentSuccessfulSyncPath)
+	ds.mostRecentSuccessfulSync.Store(&mostRecentSuccessfulSync)
+
+	return ds, nil
+}
+
+// readMostRecentSuccessfulSync reads the timestamp of the most recent successful sync from the data dir.
+// The zero time is returned if the dataset has never been synced successfully.
+func readMostRecentSuccessfulSync(dataDir string) (time.Time, error) {
+	mostRecentSuccessfulSyncPath := path.Join(dataDir, hibpMostRecentSuccessfulSyncPath)
 	mostRecentSuccessfulSyncBytes, err := os.ReadFile(mostRecentSuccessfulSyncPath)
 	if err != nil {
 		// It is ok if the file does not exist
-		if !errors.Is(err, os.ErrNotExist) {
-			return nil, fmt.Errorf("reading timestamp of most recent successful sync at %q: %w", mostRecentSuccessfulSyncPath, err)
-		}
-	} else {
-		seconds, err := strconv.ParseInt(string(mostRecentSuccessfulSyncBytes), 10, 64)
-		if err != nil {
-			return nil, fmt.Errorf("parsing timestamp %q of most recent successful sync from %q: %w", mostRecentSuccessfulSyncBytes, mostRecentSuccessfulSyncPath, err)
+		if errors.Is(err, os.ErrNotExist) {
+			return time.Time{}, nil
 		}
 
-		mostRecentSuccessfulSync = time.Unix(seconds, 0)
+		return time.Time{}, fmt.Errorf("reading timestamp of most recent successful sync at %q: %w", mostRecentSuccessfulSyncPath, err)
 	}
 
-	h := &HIBP{
-		store:   storage,
-		dataDir: config.dataDir,
+	seconds, err := strconv.ParseInt(string(mostRecentSuccessfulSyncBytes), 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("parsing timestamp %q of most recent successful sync from %q: %w", mostRecentSuccessfulSyncBytes, mostRecentSuccessfulSyncPath, err)
 	}
 
-	h.mostRecentSuccessfulSync.Store(&mostRecentSuccessfulSync)
+	return time.Unix(seconds, 0), nil
+}
 
-	return h, nil
+// refreshMostRecentSuccessfulSync re-reads the timestamp of the most recent successful sync if the file has changed,
+// as in read-only mode, the dataset is synced by another process.
+// Errors are ignored, the previous timestamp is kept then; the file might just be in the middle of being written.
+// Within timestampRefreshInterval of the previous check, it returns right away without taking any lock.
+func (ds *dataset) refreshMostRecentSuccessfulSync() {
+	now := time.Now().UnixNano()
+
+	checkedAt := ds.timestampCheckedAt.Load()
+	if now-checkedAt < int64(timestampRefreshInterval) || !ds.timestampCheckedAt.CompareAndSwap(checkedAt, now) {
+		return
+	}
+
+	ds.timestampLock.Lock()
+	defer ds.timestampLock.Unlock()
+
+	var modTime time.Time
+
+	info, err := os.Stat(path.Join(ds.dataDir, hibpMostRecentSuccessfulSyncPath))
+	if err == nil {
+		modTime = info.ModTime()
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return
+	}
+
+	if modTime.Equal(ds.timestampModTime) {
+		return
+	}
+
+	mostRecentSuccessfulSync, err := readMostRecentSuccessfulSync(ds.dataDir)
+	if err != nil {
+		return
+	}
+
+	ds.mostRecentSuccessfulSync.Store(&mostRecentSuccessfulSync)
+	ds.timestampModTime = modTime
+}
+
+func (h *HIBP) dataset(mode HashMode) (*dataset, error) {
+	ds, exists := h.datasets[mode]
+	if !exists {
+		return nil, fmt.Errorf("unsupported hash mode %v", mode)
+	}
+
+	return ds, nil
 }
 
 // Sync copies the ranges, i.e., the HIBP data, from the upstream API to the local storage.
 // The function will start from the lowest prefix and continue until the highest prefix.
+// Ranges that cannot be synced do not stop the operation; they are reported using a *SyncError once all other
+// ranges have been processed.
 // See the set of SyncOption functions for customizing the behavior of the sync operation.
-func (h *HIBP) Sync(options ...SyncOption) error {
-	config := &syncConfig{
-		ctx:                                 context.Background(),
-		endpoint:                            defaultEndpoint,
-		minWorkers:                          defaultWorkers,
-		progressFn:                          func(_, _, _, _, _ int64) error { return nil },
-		lastRange:                           defaultLastRange,
-		trackMostRecentSuccessfulSyncInFile: true,
+func (h *HIBP) Sync(options ...SyncOption) (err error) {
+	if h.readOnly {
+		return ErrReadOnly
 	}
 
-	for _, option := range options {
-		option(config)
+	config := h.newSyncConfig(options)
+
+	ds, err := h.dataset(config.mode)
+	if err != nil {
+		return err
+	}
+
+	unlock, err := ds.lock(true)
+	if err != nil {
+		return err
 	}
+	defer releaseLock(unlock, &err)
 
-	from := int64(0x00000)
+	state := newSyncState()
 
-	if config.stateFile != nil {
-		lastState, err := readStateFile(config.stateFile)
+	// attempted reports whether a range is part of this run, as opposed to being left out on purpose.
+	attempted := func(r int64) bool { return r <= config.lastRange }
+
+	var transactor syncTransactor
+
+	if config.atomic {
+		if config.stateFile != nil {
+			return errors.New("atomic syncs cannot be combined with a state file")
+		}
+
+		t, ok := ds.store.(syncTransactor)
+		if !ok {
+			return errors.New("the storage does not support atomic syncs")
+		}
+
+		transactor = t
+	}
+
+	switch {
+	case config.ranges != nil && config.stateFile != nil:
+		return errors.New("syncing specific ranges cannot be combined with a state file")
+	case config.ranges != nil:
+		requested := make(map[int64]struct{}, len(config.ranges))
+
+		for _, rangePrefix := range config.ranges {
+			r, err := parseRangeString(rangePrefix)
+			if err != nil {
+				return err
+			}
+
+			if r > config.lastRange {
+				return fmt.Errorf("range %q is beyond the last range", rangePrefix)
+			}
+
+			requested[r] = struct{}{}
+		}
+
+		// Marking all other ranges as done restricts the sync to the requested ones.
+		for r := int64(0); r <= config.lastRange; r++ {
+			if _, exists := requested[r]; !exists {
+				state.markDone(r)
+			}
+		}
+
+		attempted = func(r int64) bool {
+			_, exists := requested[r]
+			return exists
+		}
+	case config.stateFile != nil:
+		state, err = readStateFile(config.stateFile)
 		if err != nil {
 			return fmt.Errorf("error reading state file: %w", err)
 		}
 
-		from = lastState
-
-		config.progressFn = wrapWithStateUpdate(lastState, config.stateFile, config.progressFn)
+		config.progressFn = wrapWithStateUpdate(state, config.stateFile, config.progressFn)
 	}
 
 	retryClient := retryablehttp.NewClient()
@@ -108,24 +304,123 @@ func (h *HIBP) Sync(options ...SyncOption) error {
 	retryClient.Logger = nil // For now, we simply want to suppress the debug output
 
 	client := &hibpClient{
-		endpoint:   config.endpoint,
-		httpClient: retryClient.StandardClient(),
-		maxRetries: 3,
+		endpoint:    config.endpoint,
+		mode:        config.mode,
+		httpClient:  retryClient.StandardClient(),
+		maxRetries:  3,
+		ignoreETags: config.ignoreETags,
 	}
 
 	// It is important to create a non-buffering/blocking pool because we don't want to schedule all jobs upfront.
 	// This would cause problems, especially when cancelling the context.
 	pool := pond.New(config.minWorkers, 0, pond.MinWorkers(config.minWorkers))
 
-	if err := sync(config.ctx, from, config.lastRange+1, client, h.store, pool, config.progressFn); err != nil {
-		return err
+	var (
+		stats   syncStats
+		started = time.Now()
+	)
+
+	store := ds.store
+
+	// In snapshot mode, readers keep being served from the current generation until the sync completes
+	snapshots, useSnapshots := ds.store.(*snapshotStorage)
+	if useSnapshots {
+		pending, err := snapshots.beginGeneration()
+		if err != nil {
+			return fmt.Errorf("beginning generation: %w", err)
+		}
+
+		store = pending
+	}
+
+	ctx := config.ctx
+
+	// A changelog that cannot be written aborts the sync, its changes would go unrecorded otherwise
+	if config.changeLog != nil {
+		var abort context.CancelCauseFunc
+
+		ctx, abort = context.WithCancelCause(ctx)
+		defer abort(nil)
+
+		store = newChangeLogStorage(store, config.changeLog, abort)
+	}
+
+	if transactor != nil {
+		if err := transactor.BeginSync(); err != nil {
+			return fmt.Errorf("beginning atomic sync: %w", err)
+		}
+	}
+
+	syncErr := sync(ctx, 0, config.lastRange+1, client, store, pool, state, &stats, config.progressFn)
+	if ctx.Err() != nil && config.ctx.Err() == nil {
+		syncErr = errors.Join(context.Cause(ctx), syncErr)
+	}
+
+	config.resultFn(stats.result(config.mode, started))
+
+	if transactor != nil {
+		// Nothing has been applied, i.e., there is neither progress nor failed ranges to keep track of
+		if syncErr != nil {
+			return errors.Join(syncErr, transactor.AbortSync())
+		}
+
+		if err := transactor.CommitSync(); err != nil {
+			return fmt.Errorf("committing atomic sync: %w", err)
+		}
+	}
+
+	if useSnapshots && syncErr == nil {
+		if err := snapshots.commitGeneration(); err != nil {
+			return fmt.Errorf("committing generation: %w", err)
+		}
+	}
+
+	// Persisting the state once more ensures that no progress is lost, regardless of whether the sync has been
+	// successful, has failed or has been cancelled.
+	if config.stateFile != nil {
+		if err := writeStateFile(config.stateFile, state); err != nil {
+			return errors.Join(syncErr, fmt.Errorf("persisting state: %w", err))
+		}
+	}
+
+	// Replaced ranges may leave garbage behind in the storage, e.g., in packs, that has to be dropped.
+	if c, ok := ds.store.(compacter); ok && stats.updated.Load() > 0 {
+		if err := c.Compact(); err != nil {
+			return errors.Join(syncErr, fmt.Errorf("compacting storage: %w", err))
+		}
+	}
+
+	completesDataset := config.ranges == nil
+
+	// An interrupted sync has not attempted all of its ranges, which is why its failed ranges do not describe what
+	// is missing. Retrying them must not count as completing the dataset, the sync has to be continued instead.
+	if config.trackFailedRangesInFile && state.covers(0, config.lastRange+1) {
+		previous, remaining, err := ds.updateFailedRanges(state, attempted)
+		if err != nil {
+			return errors.Join(syncErr, err)
+		}
+
+		// Syncing the remainder of a previously failed sync completes it
+		completesDataset = completesDataset || (previous > 0 && remaining == 0)
+	}
+
+	if syncErr != nil {
+		return syncErr
+	}
+
+	if !completesDataset {
+		return nil
 	}
 
 	now := time.Now()
-	h.mostRecentSuccessfulSync.Store(&now)
+	ds.mostRecentSuccessfulSync.Store(&now)
 
 	if config.trackMostRecentSuccessfulSyncInFile {
-		mostRecentSuccessfulSyncPath := path.Join(h.dataDir, hibpMostRecentSuccessfulSyncPath)
+		if err := os.MkdirAll(ds.dataDir, dirMode); err != nil {
+			return fmt.Errorf("creating data directory %q: %w", ds.dataDir, err)
+		}
+
+		mostRecentSuccessfulSyncPath := path.Join(ds.dataDir, hibpMostRecentSuccessfulSyncPath)
 
 		if err := os.WriteFile(mostRecentSuccessfulSyncPath, []byte(strconv.FormatInt(now.Unix(), 10)), 0o644); err != nil {
 			return fmt.Errorf("writing timestamp of most recent successful sync to %q: %w", mostRecentSuccessfulSyncPath, err)
@@ -135,86 +430,230 @@ func (h *HIBP) Sync(options ...SyncOption) error {
 	return nil
 }
 
-// Export writes the dataset to the given writer.
-// The data is written as a continuous stream with no indication of the "prefix boundaries",
-// the format therefore differs from the official Have-I-Been-Pwned API and from `Query`, which is mimicking the API.
-// Lines have the schema "<prefix><suffix>:<count>".
-func (h *HIBP) Export(w io.Writer) error {
-	return export(0, defaultLastRange+1, h.store, w)
+// Rollback restores the generation of the dataset preceding the current one, e.g., if upstream shipped bad data.
+// It requires the snapshot mode, see WithSnapshots; ErrNoPreviousGeneration is returned if there is no generation
+// left to roll back to.
+// The current generation is discarded, as is the pending one of a sync that has not completed yet.
+// Rolling back must not run concurrently with a sync of the same hash family.
+func (h *HIBP) Rollback(options ...RollbackOption) (err error) {
+	if h.readOnly {
+		return ErrReadOnly
+	}
+
+	config := &rollbackConfig{
+		mode: h.mode,
+	}
+
+	for _, option := range options {
+		option(config)
+	}
+
+	ds, err := h.dataset(config.mode)
+	if err != nil {
+		return err
+	}
+
+	snapshots, ok := ds.store.(*snapshotStorage)
+	if !ok {
+		return errors.New("rolling back requires the snapshot mode, see WithSnapshots")
+	}
+
+	unlock, err := ds.lock(true)
+	if err != nil {
+		return err
+	}
+	defer releaseLock(unlock, &err)
+
+	return snapshots.rollback()
 }
 
-// Query queries the local dataset for the given prefix.
-// The function returns an io.ReadCloser that can be used to read the data, it should be closed as soon as possible
-// to release the read lock on the file.
-// It is the responsibility of the caller to close the returned io.ReadCloser.
-// The resulting lines do NOT start with the prefix, they are following the schema "<suffix>:<count>".
-// This is equivalent to the response of the official Have-I-Been-Pwned API.
-func (h *HIBP) Query(prefix string) (io.ReadCloser, error) {
-	reader, err := h.store.LoadData(prefix)
+// RetryFailed re-requests only the ranges that failed during previous syncs.
+// It relies on the failed ranges being tracked in the data dir, see SyncWithoutTrackingFailedRangesInFile.
+// The same options as for Sync are supported.
+// Once all previously failed ranges have been synced successfully, the sync counts as successful.
+// Ranges failing during a sync that is interrupted, e.g., by cancelling its context, are not tracked; such a sync has
+// to be continued using a state file or run again instead.
+func (h *HIBP) RetryFailed(options ...SyncOption) error {
+	if h.readOnly {
+		return ErrReadOnly
+	}
+
+	config := h.newSyncConfig(options)
+
+	ds, err := h.dataset(config.mode)
 	if err != nil {
-		return nil, fmt.Errorf("loading data for prefix %q: %w", prefix, err)
+		return err
+	}
+
+	failed, err := readFailedRangesFile(ds.failedRangesPath())
+	if err != nil {
+		return err
 	}
 
-	return reader, nil
+	if len(failed) == 0 {
+		return nil
+	}
+
+	ranges := make([]string, 0, len(failed))
+	for _, r := range failed {
+		ranges = append(ranges, toRangeString(r))
+	}
+
+	return h.Sync(append(options, SyncWithRanges(ranges))...)
 }
 
-// MostRecentSuccessfulSync returns the point in the most recent successful sync finished.
-func (h *HIBP) MostRecentSuccessfulSync() time.Time {
-	return *h.mostRecentSuccessfulSync.Load()
+func (h *HIBP) newSyncConfig(options []SyncOption) *syncConfig {
+	config := &syncConfig{
+		ctx:                                 context.Background(),
+		endpoint:                            defaultEndpoint,
+		mode:                                h.mode,
+		minWorkers:                          defaultWorkers,
+		progressFn:                          func(_, _, _, _, _ int64) error { return nil },
+		resultFn:                            func(SyncResult) {},
+		lastRange:                           defaultLastRange,
+		trackMostRecentSuccessfulSyncInFile: true,
+		trackFailedRangesInFile:             true,
+	}
+
+	for _, option := range options {
+		option(config)
+	}
+
+	return config
+}
+
+func (ds *dataset) failedRangesPath() string {
+	return path.Join(ds.dataDir, hibpFailedRangesPath)
 }
 
-func readStateFile(stateFile io.ReadWriteSeeker) (int64, error) {
-	state, err := io.ReadAll(stateFile)
+// updateFailedRanges merges the outcome of a sync into the set of failed ranges tracked in the data dir.
+// Ranges that have been attempted and completed are removed, the ones that failed are added.
+// It returns the number of ranges that had failed before and the number of ranges that remain failed.
+func (ds *dataset) updateFailedRanges(state *syncState, attempted func(int64) bool) (int, int, error) {
+	previouslyFailed, err := readFailedRangesFile(ds.failedRangesPath())
 	if err != nil {
-		if errors.Is(err, os.ErrNotExist) {
-			return 0, nil
+		return 0, 0, err
+	}
+
+	failed := state.failedRanges()
+
+	for _, r := range previouslyFailed {
+		if !attempted(r) || !state.isDone(r) {
+			failed = append(failed, r)
+		}
+	}
+
+	slices.Sort(failed)
+	failed = slices.Compact(failed)
+
+	if len(failed) > 0 {
+		if err := os.MkdirAll(ds.dataDir, dirMode); err != nil {
+			return 0, 0, fmt.Errorf("creating data directory %q: %w", ds.dataDir, err)
 		}
+	}
 
-		return 0, fmt.Errorf("reading state file: %w", err)
+	if err := writeFailedRangesFile(ds.failedRangesPath(), failed); err != nil {
+		return 0, 0, err
 	}
 
-	state = bytes.TrimSpace(state)
+	return len(previouslyFailed), len(failed), nil
+}
+
+// Export writes the dataset to the given writer.
+// The data is written as a continuous stream with no indication of the "prefix boundaries",
+// the format therefore differs from the official Have-I-Been-Pwned API and from `Query`, which is mimicking the API.
+// Lines have the schema "<prefix><suffix>:<count>".
+func (h *HIBP) Export(w io.Writer, options ...ExportOption) (err error) {
+	config := &exportConfig{
+		mode: h.mode,
+	}
 
-	if len(state) == 0 {
-		return 0, nil
+	for _, option := range options {
+		option(config)
 	}
 
-	lastState, err := strconv.ParseInt(string(state), 10, 64)
+	ds, err := h.dataset(config.mode)
 	if err != nil {
-		return 0, fmt.Errorf("parsing state file: %w", err)
+		return err
 	}
 
-	if _, err := stateFile.Seek(0, io.SeekStart); err != nil {
-		return 0, fmt.Errorf("seeking to beginning of state file: %w", err)
+	unlock, err := ds.readLock()
+	if err != nil {
+		return err
 	}
+	defer releaseLock(unlock, &err)
 
-	return lastState, nil
+	return export(0, defaultLastRange+1, ds.store, w)
 }
 
-func wrapWithStateUpdate(startingState int64, stateFile io.ReadWriteSeeker, innerProgressFn ProgressFunc) ProgressFunc {
-	return func(lowest, current, to, processed, remaining int64) error {
-		err := func() error {
-			if lowest < startingState+1000 && remaining > 0 {
-				return nil
-			}
+// Query queries the local dataset for the given prefix.
+// The function returns an io.ReadCloser that can be used to read the data, it should be closed as soon as possible
+// to release the read lock on the file.
+// It is the responsibility of the caller to close the returned io.ReadCloser.
+// The resulting lines do NOT start with the prefix, they are following the schema "<suffix>:<count>".
+// This is equivalent to the response of the official Have-I-Been-Pwned API.
+func (h *HIBP) Query(prefix string, options ...QueryOption) (io.ReadCloser, error) {
+	config := &queryConfig{
+		mode: h.mode,
+	}
 
-			if _, err := stateFile.Seek(0, io.SeekStart); err != nil {
-				return fmt.Errorf("seeking to beginning of state file: %w", err)
-			}
+	for _, option := range options {
+		option(config)
+	}
 
-			if _, err := stateFile.Write([]byte(fmt.Sprintf("%d", lowest))); err != nil {
-				return fmt.Errorf("writing state file: %w", err)
-			}
+	ds, err := h.dataset(config.mode)
+	if err != nil {
+		return nil, err
+	}
+
+	reader, err := ds.store.LoadData(strings.ToUpper(prefix))
+	if err != nil {
+		return nil, fmt.Errorf("loading data for prefix %q: %w", prefix, err)
+	}
 
-			startingState = lowest
+	if !config.padding {
+		return reader, nil
+	}
 
-			return nil
-		}()
+	defer reader.Close()
 
-		if err != nil {
-			fmt.Printf("updating state file: %v\n", err)
-		}
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("reading data for prefix %q: %w", prefix, err)
+	}
+
+	return io.NopCloser(bytes.NewReader(padRange(data, config.mode.hashLength()-prefixLength))), nil
+}
+
+// CacheStats returns the statistics of the range cache, see WithCache.
+// The zero value is returned if caching is disabled.
+func (h *HIBP) CacheStats() CacheStats {
+	if h.cache == nil {
+		return CacheStats{}
+	}
+
+	return h.cache.statistics()
+}
 
-		return innerProgressFn(lowest, current, to, processed, remaining)
+// MostRecentSuccessfulSync returns the point in the most recent successful sync finished.
+// It refers to the hash family configured using WithHashMode, see MostRecentSuccessfulSyncOf for other modes.
+func (h *HIBP) MostRecentSuccessfulSync() time.Time {
+	return h.MostRecentSuccessfulSyncOf(h.mode)
+}
+
+// MostRecentSuccessfulSyncOf returns the point in the most recent successful sync of the given hash family finished.
+// The zero time is returned for unsupported modes or if the dataset has never been synced successfully.
+// In read-only mode, see WithReadOnly, the timestamp is re-read from the data dir whenever it has changed, so syncs
+// run by other processes are picked up within a second.
+func (h *HIBP) MostRecentSuccessfulSyncOf(mode HashMode) time.Time {
+	ds, err := h.dataset(mode)
+	if err != nil {
+		return time.Time{}
+	}
+
+	if ds.readOnly {
+		ds.refreshMostRecentSuccessfulSync()
 	}
+
+	return *ds.mostRecentSuccessfulSync.Load()
 }
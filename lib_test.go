@@ -2,19 +2,28 @@ package hibp
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"go.uber.org/mock/gomock"
 	"io"
 	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	syncPkg "sync"
 	"testing"
 )
 
 func TestQuery(t *testing.T) {
 	ctrl := gomock.NewController(t)
-	storageMock := NewMockstorage(ctrl)
+	storageMock := NewMockStorage(ctrl)
 
 	storageMock.EXPECT().LoadData("00000").Return(io.NopCloser(bytes.NewReader([]byte("suffix:counter11\r\nsuffix:counter12"))), nil)
 
-	i := HIBP{store: storageMock}
+	i := HIBP{datasets: map[HashMode]*dataset{ModeSHA1: {store: storageMock}}}
 
 	reader, err := i.Query("00000")
 	if err != nil {
@@ -34,6 +43,234 @@ func TestQuery(t *testing.T) {
 	}
 }
 
+func TestWithStorage(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	sha1Mock := NewMockStorage(ctrl)
+	ntlmMock := NewMockStorage(ctrl)
+
+	sha1Mock.EXPECT().LoadData("ABCDE").Return(io.NopCloser(bytes.NewReader([]byte("sha1"))), nil)
+	ntlmMock.EXPECT().LoadData("ABCDE").Return(io.NopCloser(bytes.NewReader([]byte("ntlm"))), nil)
+
+	h, err := New(WithDataDir(t.TempDir()), WithHashMode(ModeNTLM), WithStorage(ntlmMock), WithModeStorage(ModeSHA1, sha1Mock))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Keys are always passed in upper-case to the storage
+	for _, mode := range hashModes {
+		reader, err := h.Query("abcde", QueryWithMode(mode))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		data, err := io.ReadAll(reader)
+		_ = reader.Close()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if string(data) != mode.String() {
+			t.Fatalf("unexpected storage used for mode %v: %q", mode, data)
+		}
+	}
+}
+
+func TestQueryWithMode(t *testing.T) {
+	dataDir := t.TempDir()
+
+	h, err := New(WithDataDir(dataDir))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := h.datasets[ModeSHA1].store.Save("00000", "etag", []byte("sha1suffix:1")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := h.datasets[ModeNTLM].store.Save("00000", "etag", []byte("ntlmsuffix:2")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The NTLM dataset must not interfere with the SHA-1 dataset, which stays at its original location.
+	if _, err := os.Stat(path.Join(dataDir, ntlmSubDir, "00", "000")); err != nil {
+		t.Fatalf("expected NTLM range file to exist: %v", err)
+	}
+
+	for mode, expected := range map[HashMode]string{ModeSHA1: "sha1suffix:1", ModeNTLM: "ntlmsuffix:2"} {
+		reader, err := h.Query("00000", QueryWithMode(mode))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		lines, err := io.ReadAll(reader)
+		_ = reader.Close()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if string(lines) != expected {
+			t.Fatalf("unexpected output for mode %v: %q", mode, string(lines))
+		}
+	}
+
+	if _, err := h.Query("00000", QueryWithMode(HashMode(42))); err == nil {
+		t.Fatalf("expected an error for an unsupported mode")
+	}
+}
+
+func TestSyncRetryFailed(t *testing.T) {
+	var (
+		lock      syncPkg.Mutex
+		requested []string
+		failing   = true
+	)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		prefix := strings.TrimPrefix(r.URL.Path, "/range/")
+		requested = append(requested, prefix)
+
+		// 404 is not retried by the HTTP client, which keeps the test fast
+		if prefix == "00001" && failing {
+			w.WriteHeader(http.StatusNotFound)
+
+			return
+		}
+
+		w.Header().Set("ETag", "etag")
+		_, _ = w.Write([]byte("suffix:" + prefix))
+	}))
+	defer server.Close()
+
+	dataDir := t.TempDir()
+
+	h, err := New(WithDataDir(dataDir))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	syncOptions := []SyncOption{SyncWithEndpoint(server.URL + "/range/"), SyncWithLastRange(2), SyncWithMinWorkers(2)}
+
+	var result SyncResult
+
+	err = h.Sync(append(syncOptions, SyncWithResultFn(func(r SyncResult) { result = r }))...)
+
+	if result.Updated != 2 || result.Failed != 1 || result.BytesWritten == 0 {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+
+	var syncErr *SyncError
+	if !errors.As(err, &syncErr) {
+		t.Fatalf("expected a SyncError, got: %v", err)
+	}
+
+	if !reflect.DeepEqual(syncErr.Prefixes(), []string{"00001"}) {
+		t.Fatalf("unexpected failed ranges: %v", syncErr.Prefixes())
+	}
+
+	if !h.MostRecentSuccessfulSync().IsZero() {
+		t.Fatalf("a failed sync must not count as successful")
+	}
+
+	failedRanges, err := os.ReadFile(path.Join(dataDir, hibpFailedRangesPath))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if string(failedRanges) != "00001\n" {
+		t.Fatalf("unexpected failed ranges file: %q", failedRanges)
+	}
+
+	lock.Lock()
+	failing = false
+	requested = nil
+	lock.Unlock()
+
+	if err := h.RetryFailed(syncOptions...); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !reflect.DeepEqual(requested, []string{"00001"}) {
+		t.Fatalf("unexpected ranges requested during retry: %v", requested)
+	}
+
+	if _, err := os.Stat(path.Join(dataDir, hibpFailedRangesPath)); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected failed ranges file to be removed: %v", err)
+	}
+
+	if h.MostRecentSuccessfulSync().IsZero() {
+		t.Fatalf("completing the failed ranges should count as successful sync")
+	}
+
+	if err := h.Sync(append(syncOptions, SyncWithRanges([]string{"0000G"}))...); err == nil {
+		t.Fatalf("expected an error for an invalid range")
+	}
+}
+
+func TestSyncRetryFailedAfterCancellation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var (
+		lock    syncPkg.Mutex
+		failing = true
+	)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		prefix := strings.TrimPrefix(r.URL.Path, "/range/")
+
+		if failing {
+			switch prefix {
+			case "00000":
+				w.WriteHeader(http.StatusNotFound)
+
+				return
+			case "00001":
+				// Interrupts the sync long before it gets through all ranges
+				cancel()
+			}
+		}
+
+		w.Header().Set("ETag", "etag")
+		_, _ = w.Write([]byte("suffix:" + prefix))
+	}))
+	defer server.Close()
+
+	dataDir := t.TempDir()
+
+	h, err := New(WithDataDir(dataDir))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	syncOptions := []SyncOption{SyncWithEndpoint(server.URL + "/range/"), SyncWithLastRange(0xFFF), SyncWithMinWorkers(1)}
+
+	if err := h.Sync(append(syncOptions, SyncWithContext(ctx))...); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the sync to be cancelled, got: %v", err)
+	}
+
+	lock.Lock()
+	failing = false
+	lock.Unlock()
+
+	if err := h.RetryFailed(syncOptions...); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !h.MostRecentSuccessfulSync().IsZero() {
+		t.Fatalf("retrying the failed ranges of a cancelled sync must not count as successful sync")
+	}
+
+	if _, err := os.Stat(path.Join(dataDir, hibpMostRecentSuccessfulSyncPath)); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected no timestamp of the most recent successful sync: %v", err)
+	}
+}
+
 func BenchmarkQuery(b *testing.B) {
 	const lastRange = 0x0000A
 
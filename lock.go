@@ -0,0 +1,249 @@
+package hibp
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	syncPkg "sync"
+)
+
+// The data dir of the file-based storage is protected by an advisory lock on the file ".lock", so that operations
+// modifying it, e.g., Sync or Import, are not run by several processes at the same time.
+// Writers hold the lock exclusively and record their PID in the file; readers optionally hold it shared, see
+// WithSharedLocks.
+// The lock is released by the operating system when its holder dies, a PID left behind in the file is stale then and
+// simply overwritten.
+// On file systems not supporting advisory locks, writers create the file ".lock.pid" exclusively instead, recording
+// their PID, and remove it again on release; it is considered stale once the recorded process is no longer alive.
+// Shared locks are not enforced in this fallback mode: readers only check for a writer, they are not recorded, so
+// writers do not wait for them.
+const (
+	hibpLockPath    = ".lock"
+	hibpPIDLockPath = ".lock.pid"
+)
+
+// ErrDataDirLocked is returned if an operation cannot lock the data dir, because it is in use by another operation,
+// possibly of another process.
+var ErrDataDirLocked = errors.New("data dir is locked")
+
+var (
+	// errLockWouldBlock is returned by lockFile if the lock is held by someone else.
+	errLockWouldBlock = errors.New("lock is held by someone else")
+	// errLockUnsupported is returned by lockFile if the file system does not support advisory locks.
+	errLockUnsupported = errors.New("advisory locks are not supported")
+)
+
+// lock locks the data dir of the dataset, either exclusively for writing or shared for reading, and returns the
+// function releasing the lock again.
+// Datasets not using the file-based storage are not locked, as their data dir might not even exist.
+func (ds *dataset) lock(exclusive bool) (func() error, error) {
+	switch ds.store.(type) {
+	case *fsStorage, *snapshotStorage:
+	default:
+		return func() error { return nil }, nil
+	}
+
+	return lockDataDir(ds.dataDir, exclusive, ds.readOnly)
+}
+
+// LockDataDir locks the data dir of a dataset exclusively, the same way Sync and Import do, and returns the function
+// releasing the lock again.
+// It allows tools operating on the data dir directly to keep other processes from modifying it in the meantime;
+// ErrDataDirLocked is returned if the data dir is in use.
+// The data dir of the NTLM dataset is the subdirectory "ntlm" of the one of the SHA-1 dataset, both are locked
+// independently.
+func LockDataDir(dataDir string) (func() error, error) {
+	return lockDataDir(dataDir, true, false)
+}
+
+func lockDataDir(dataDir string, exclusive, readOnly bool) (func() error, error) {
+	lockPath := path.Join(dataDir, hibpLockPath)
+
+	var (
+		file *os.File
+		err  error
+	)
+
+	if readOnly {
+		// Only shared locks are taken in read-only mode; if there is no lock file, nobody is writing to the data dir.
+		file, err = os.Open(lockPath)
+		if errors.Is(err, os.ErrNotExist) {
+			return func() error { return nil }, nil
+		}
+	} else {
+		if err := os.MkdirAll(dataDir, dirMode); err != nil {
+			return nil, fmt.Errorf("creating data directory %q: %w", dataDir, err)
+		}
+
+		file, err = os.OpenFile(lockPath, os.O_RDWR|os.O_CREATE, 0o644)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("opening lock file %q: %w", lockPath, err)
+	}
+
+	err = lockFile(file, exclusive)
+	if errors.Is(err, errLockUnsupported) {
+		_ = file.Close()
+
+		return lockDataDirByPID(dataDir, exclusive)
+	}
+
+	if err != nil {
+		defer file.Close()
+
+		if errors.Is(err, errLockWouldBlock) {
+			if pid := readLockPID(file); pid != 0 {
+				return nil, fmt.Errorf("%w: %q is held by process %d", ErrDataDirLocked, dataDir, pid)
+			}
+
+			return nil, fmt.Errorf("%w: %q is in use", ErrDataDirLocked, dataDir)
+		}
+
+		return nil, fmt.Errorf("locking %q: %w", lockPath, err)
+	}
+
+	if exclusive {
+		if err := writeLockPID(file, os.Getpid()); err != nil {
+			_ = unlockFile(file)
+			_ = file.Close()
+
+			return nil, fmt.Errorf("writing lock file %q: %w", lockPath, err)
+		}
+	}
+
+	return syncPkg.OnceValue(func() error {
+		defer file.Close()
+
+		if exclusive {
+			if err := writeLockPID(file, 0); err != nil {
+				return fmt.Errorf("clearing lock file %q: %w", lockPath, err)
+			}
+		}
+
+		return unlockFile(file)
+	}), nil
+}
+
+// lockDataDirByPID locks the data dir on file systems not supporting advisory locks by creating the PID lock file
+// exclusively. A lock file left behind by a process that is no longer alive is taken over.
+func lockDataDirByPID(dataDir string, exclusive bool) (func() error, error) {
+	lockPath := path.Join(dataDir, hibpPIDLockPath)
+
+	locked := func(pid int) error {
+		if pid != 0 {
+			return fmt.Errorf("%w: %q is held by process %d", ErrDataDirLocked, dataDir, pid)
+		}
+
+		return fmt.Errorf("%w: %q is in use", ErrDataDirLocked, dataDir)
+	}
+
+	if !exclusive {
+		if pid, err := readPIDLockFile(lockPath); err == nil && (pid == 0 || processAlive(pid)) {
+			return nil, locked(pid)
+		} else if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("reading lock file %q: %w", lockPath, err)
+		}
+
+		return func() error { return nil }, nil
+	}
+
+	for takenOver := false; ; takenOver = true {
+		file, err := os.OpenFile(lockPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+		if err == nil {
+			err = writeLockPID(file, os.Getpid())
+			if closeErr := file.Close(); err == nil {
+				err = closeErr
+			}
+
+			if err != nil {
+				_ = os.Remove(lockPath)
+
+				return nil, fmt.Errorf("writing lock file %q: %w", lockPath, err)
+			}
+
+			return syncPkg.OnceValue(func() error {
+				if err := os.Remove(lockPath); err != nil {
+					return fmt.Errorf("removing lock file %q: %w", lockPath, err)
+				}
+
+				return nil
+			}), nil
+		}
+
+		if !errors.Is(err, os.ErrExist) {
+			return nil, fmt.Errorf("creating lock file %q: %w", lockPath, err)
+		}
+
+		// An empty lock file is considered held, its holder might not have recorded its PID yet
+		pid, err := readPIDLockFile(lockPath)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("reading lock file %q: %w", lockPath, err)
+		}
+
+		if takenOver || (err == nil && (pid == 0 || processAlive(pid))) {
+			return nil, locked(pid)
+		}
+
+		if err := os.Remove(lockPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("removing stale lock file %q: %w", lockPath, err)
+		}
+	}
+}
+
+// releaseLock calls the function releasing a lock, joining its error into the one of the operation holding the lock.
+// It is meant to be deferred, passing the named error result of the operation.
+func releaseLock(unlock func() error, err *error) {
+	*err = errors.Join(*err, unlock())
+}
+
+// readLock locks the data dir of the dataset shared for reading, if enabled using WithSharedLocks.
+func (ds *dataset) readLock() (func() error, error) {
+	if !ds.sharedLocks {
+		return func() error { return nil }, nil
+	}
+
+	return ds.lock(false)
+}
+
+func readLockPID(file *os.File) int {
+	buf := make([]byte, 32)
+
+	n, _ := file.ReadAt(buf, 0)
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(buf[:n])))
+	if err != nil {
+		return 0
+	}
+
+	return pid
+}
+
+// readPIDLockFile returns the PID recorded in the PID lock file, 0 if there is none (yet).
+func readPIDLockFile(lockPath string) (int, error) {
+	file, err := os.Open(lockPath)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	return readLockPID(file), nil
+}
+
+// writeLockPID records the PID of the holder of the lock, 0 clears it.
+func writeLockPID(file *os.File, pid int) error {
+	if err := file.Truncate(0); err != nil {
+		return err
+	}
+
+	if pid == 0 {
+		return nil
+	}
+
+	_, err := file.WriteAt([]byte(strconv.Itoa(pid)+"\n"), 0)
+
+	return err
+}
@@ -0,0 +1,21 @@
+//go:build !unix && !windows
+
+package hibp
+
+import "os"
+
+// lockFile reports advisory locks to be unsupported, the data dir is locked using the PID of the holder instead.
+func lockFile(_ *os.File, _ bool) error {
+	return errLockUnsupported
+}
+
+func unlockFile(_ *os.File) error {
+	return nil
+}
+
+// processAlive reports whether a process with the given PID exists.
+func processAlive(pid int) bool {
+	_, err := os.FindProcess(pid)
+
+	return err == nil
+}
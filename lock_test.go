@@ -0,0 +1,169 @@
+package hibp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestDataDirLock(t *testing.T) {
+	dataDir := t.TempDir()
+
+	h, err := New(WithDataDir(dataDir))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ds, err := h.dataset(ModeSHA1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	unlock, err := ds.lock(true)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Another instance behaves like another process, as the lock is bound to the open lock file
+	other, err := New(WithDataDir(dataDir))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	err = other.Import(strings.NewReader(""))
+	if !errors.Is(err, ErrDataDirLocked) {
+		t.Fatalf("expected ErrDataDirLocked, got %v", err)
+	}
+
+	if !strings.Contains(err.Error(), fmt.Sprintf("process %d", os.Getpid())) {
+		t.Fatalf("expected the error to name the holder, got %v", err)
+	}
+
+	if err := unlock(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if pid, err := os.ReadFile(path.Join(dataDir, hibpLockPath)); err != nil || len(pid) != 0 {
+		t.Fatalf("expected the lock file to be cleared, got %q, %v", pid, err)
+	}
+
+	if err := other.Import(strings.NewReader("")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestDataDirSharedLock(t *testing.T) {
+	dataDir := t.TempDir()
+
+	h, err := New(WithDataDir(dataDir), WithSharedLocks())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ds, err := h.dataset(ModeSHA1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	unlock, err := ds.readLock()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer unlock()
+
+	// Readers do not exclude each other; the export fails because the dataset is empty
+	if err := h.Export(io.Discard); err == nil || errors.Is(err, ErrDataDirLocked) {
+		t.Fatalf("expected the export to fail on the missing ranges, got %v", err)
+	}
+
+	if err := h.Import(strings.NewReader("")); !errors.Is(err, ErrDataDirLocked) {
+		t.Fatalf("expected ErrDataDirLocked, got %v", err)
+	}
+}
+
+func TestDataDirStaleLock(t *testing.T) {
+	dataDir := t.TempDir()
+
+	// The PID of a process that died without releasing the lock is left behind
+	if err := os.WriteFile(path.Join(dataDir, hibpLockPath), []byte("2147483647\n"), 0o644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	h, err := New(WithDataDir(dataDir))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := h.Import(strings.NewReader("")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if processAlive(2147483647) {
+		t.Fatal("expected the process to be reported as dead")
+	}
+
+	if !processAlive(os.Getpid()) {
+		t.Fatal("expected the own process to be reported as alive")
+	}
+}
+
+func TestDataDirPIDLock(t *testing.T) {
+	dataDir := t.TempDir()
+	lockPath := path.Join(dataDir, hibpPIDLockPath)
+
+	unlock, err := lockDataDirByPID(dataDir, true)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The lock excludes other holders of the same process as well
+	for _, exclusive := range []bool{true, false} {
+		_, err := lockDataDirByPID(dataDir, exclusive)
+		if !errors.Is(err, ErrDataDirLocked) {
+			t.Fatalf("expected ErrDataDirLocked, got %v", err)
+		}
+
+		if !strings.Contains(err.Error(), fmt.Sprintf("process %d", os.Getpid())) {
+			t.Fatalf("expected the error to name the holder, got %v", err)
+		}
+	}
+
+	if err := unlock(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := os.Stat(lockPath); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected the lock file to be removed, got %v", err)
+	}
+
+	// The lock file of a process that died without releasing the lock is taken over
+	if err := os.WriteFile(lockPath, []byte("2147483647\n"), 0o644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	unlockShared, err := lockDataDirByPID(dataDir, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := unlockShared(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	unlock, err = lockDataDirByPID(dataDir, true)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if pid, err := readPIDLockFile(lockPath); err != nil || pid != os.Getpid() {
+		t.Fatalf("expected the own PID to be recorded, got %d, %v", pid, err)
+	}
+
+	if err := unlock(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,45 @@
+//go:build windows
+
+package hibp
+
+import (
+	"errors"
+	"golang.org/x/sys/windows"
+	"os"
+)
+
+// Locks are mandatory on Windows, locking the PID recorded at the start of the lock file would keep others from
+// reading it. The lock therefore covers a single byte far beyond it, which is allowed even if the file is shorter.
+const lockFileOffsetHigh = 1
+
+// lockFile acquires a lock on the given file without blocking.
+func lockFile(file *os.File, exclusive bool) error {
+	flags := uint32(windows.LOCKFILE_FAIL_IMMEDIATELY)
+	if exclusive {
+		flags |= windows.LOCKFILE_EXCLUSIVE_LOCK
+	}
+
+	err := windows.LockFileEx(windows.Handle(file.Fd()), flags, 0, 1, 0, &windows.Overlapped{OffsetHigh: lockFileOffsetHigh})
+
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, windows.ERROR_LOCK_VIOLATION), errors.Is(err, windows.ERROR_IO_PENDING):
+		return errLockWouldBlock
+	case errors.Is(err, windows.ERROR_NOT_SUPPORTED), errors.Is(err, windows.ERROR_INVALID_FUNCTION):
+		return errLockUnsupported
+	default:
+		return err
+	}
+}
+
+func unlockFile(file *os.File) error {
+	return windows.UnlockFileEx(windows.Handle(file.Fd()), 0, 1, 0, &windows.Overlapped{OffsetHigh: lockFileOffsetHigh})
+}
+
+// processAlive reports whether a process with the given PID exists.
+func processAlive(pid int) bool {
+	_, err := os.FindProcess(pid)
+
+	return err == nil
+}
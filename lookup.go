@@ -0,0 +1,171 @@
+package hibp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const prefixLength = 5
+
+// Lookup looks up the given hex-encoded hash in the local dataset.
+// It returns how often the hash has been seen in breaches and whether it is part of the dataset at all.
+// The hash is expected to be a SHA-1 hash unless the hash family is changed using WithHashMode or QueryWithMode.
+// The range is scanned line by line, i.e., the function does not need to hold the whole range in memory, unless the
+// storage is able to look up the hash more efficiently, e.g., when using WithBinaryFormat.
+func (h *HIBP) Lookup(ctx context.Context, hash string, options ...QueryOption) (int64, bool, error) {
+	config := &queryConfig{
+		mode: h.mode,
+	}
+
+	for _, option := range options {
+		option(config)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return 0, false, err
+	}
+
+	if len(hash) != config.mode.hashLength() {
+		return 0, false, fmt.Errorf("invalid %s hash %q: expected %d hex characters", config.mode, hash, config.mode.hashLength())
+	}
+
+	if _, err := hex.DecodeString(hash); err != nil {
+		return 0, false, fmt.Errorf("invalid %s hash %q: %w", config.mode, hash, err)
+	}
+
+	hash = strings.ToUpper(hash)
+	prefix, suffix := hash[:prefixLength], hash[prefixLength:]
+
+	ds, err := h.dataset(config.mode)
+	if err != nil {
+		return 0, false, err
+	}
+
+	if l, ok := ds.store.(suffixLookuper); ok {
+		count, found, err := l.LookupSuffix(prefix, suffix)
+		if err != nil {
+			return 0, false, fmt.Errorf("looking up hash in range %q: %w", prefix, err)
+		}
+
+		return count, found, nil
+	}
+
+	reader, err := ds.store.LoadData(prefix)
+	if err != nil {
+		return 0, false, fmt.Errorf("loading data for prefix %q: %w", prefix, err)
+	}
+	defer reader.Close()
+
+	count, found, err := scanRange(reader, suffix)
+	if err != nil {
+		return 0, false, fmt.Errorf("scanning range %q: %w", prefix, err)
+	}
+
+	return count, found, nil
+}
+
+// suffixLookuper is implemented by storages that are able to look up a single suffix within a range without the
+// caller having to scan the whole range.
+type suffixLookuper interface {
+	LookupSuffix(key, suffix string) (int64, bool, error)
+}
+
+// scanRange looks up the given upper-case suffix in a range in the text format of the upstream API.
+// The range is read line by line and only as far as necessary, as ranges are sorted.
+func scanRange(r io.Reader, suffix string) (int64, bool, error) {
+	bufReader, ok := r.(*bufio.Reader)
+	if !ok {
+		bufReader = getBufReader(r)
+		defer putBufReader(bufReader)
+	}
+
+	for {
+		line, err := bufReader.ReadSlice('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return 0, false, err
+		}
+
+		if len(line) > 0 {
+			if count, found, done, err := matchRangeLine(line, suffix); err != nil || done {
+				return count, found, err
+			}
+		}
+
+		if err != nil {
+			return 0, false, nil
+		}
+	}
+}
+
+// scanRangeData is like scanRange but looks up the suffix in a range held in memory.
+func scanRangeData(data []byte, suffix string) (int64, bool, error) {
+	for len(data) > 0 {
+		var line []byte
+		line, data, _ = bytes.Cut(data, []byte("\n"))
+
+		if count, found, done, err := matchRangeLine(line, suffix); err != nil || done {
+			return count, found, err
+		}
+	}
+
+	return 0, false, nil
+}
+
+// matchRangeLine compares a single line of a range to the suffix looked up.
+// It reports whether scanning is done, i.e., the suffix has been found or, as ranges are sorted, passed.
+func matchRangeLine(line []byte, suffix string) (int64, bool, bool, error) {
+	line = bytes.TrimSuffix(bytes.TrimSuffix(line, []byte("\n")), []byte("\r"))
+
+	lineSuffix, count, found := bytes.Cut(line, []byte(":"))
+	if !found {
+		return 0, false, false, fmt.Errorf("malformed line %q", line)
+	}
+
+	// Comparing the converted strings directly does not allocate
+	switch {
+	case string(lineSuffix) < suffix:
+		return 0, false, false, nil
+	case string(lineSuffix) > suffix:
+		return 0, false, true, nil
+	}
+
+	n, err := parseCount(count)
+	if err != nil {
+		return 0, false, false, fmt.Errorf("parsing count of line %q: %w", line, err)
+	}
+
+	return n, true, true, nil
+}
+
+// parseCount parses the decimal count of a line without converting it to a string first.
+func parseCount(count []byte) (int64, error) {
+	if len(count) == 0 || len(count) > 18 {
+		return 0, fmt.Errorf("invalid count %q", count)
+	}
+
+	var n int64
+
+	for _, c := range count {
+		if c < '0' || c > '9' {
+			return 0, fmt.Errorf("invalid count %q", count)
+		}
+
+		n = n*10 + int64(c-'0')
+	}
+
+	return n, nil
+}
+
+// CheckPassword hashes the given password using SHA-1 and looks it up in the local SHA-1 dataset, see Lookup.
+func (h *HIBP) CheckPassword(password string) (int64, bool, error) {
+	sum := sha1.Sum([]byte(password))
+
+	return h.Lookup(context.Background(), hex.EncodeToString(sum[:]), QueryWithMode(ModeSHA1))
+}
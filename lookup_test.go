@@ -0,0 +1,104 @@
+package hibp
+
+import (
+	"bytes"
+	"context"
+	"go.uber.org/mock/gomock"
+	"io"
+	"testing"
+)
+
+func TestLookup(t *testing.T) {
+	// SHA-1 of "password" is 5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8
+	const rangeData = "1E4C9B93F3F0682250B6CF8331B7EE68FD7:3\r\n1E4C9B93F3F0682250B6CF8331B7EE68FD8:10434004\r\n1E4C9B93F3F0682250B6CF8331B7EE68FD9:0"
+
+	ctrl := gomock.NewController(t)
+	storageMock := NewMockStorage(ctrl)
+
+	storageMock.EXPECT().LoadData("5BAA6").DoAndReturn(func(_ string) (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader([]byte(rangeData))), nil
+	}).Times(3)
+
+	h := &HIBP{datasets: map[HashMode]*dataset{ModeSHA1: {store: storageMock}}}
+
+	count, found, err := h.CheckPassword("password")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !found || count != 10434004 {
+		t.Fatalf("unexpected result: found=%v, count=%d", found, count)
+	}
+
+	// Hashes are accepted regardless of their case
+	count, found, err = h.Lookup(context.Background(), "5baa61e4c9b93f3f0682250b6cf8331b7ee68fd7")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !found || count != 3 {
+		t.Fatalf("unexpected result: found=%v, count=%d", found, count)
+	}
+
+	_, found, err = h.Lookup(context.Background(), "5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if found {
+		t.Fatalf("expected hash to not be found")
+	}
+
+	for _, invalid := range []string{"5BAA6", "XBAA61E4C9B93F3F0682250B6CF8331B7EE68FD8"} {
+		if _, _, err := h.Lookup(context.Background(), invalid); err == nil {
+			t.Fatalf("expected an error for invalid hash %q", invalid)
+		}
+	}
+}
+
+func TestLookupBinaryFormat(t *testing.T) {
+	const rangeData = "1E4C9B93F3F0682250B6CF8331B7EE68FD7:3\r\n1E4C9B93F3F0682250B6CF8331B7EE68FD8:10434004\r\n1E4C9B93F3F0682250B6CF8331B7EE68FD9:0"
+
+	h, err := New(WithDataDir(t.TempDir()), WithBinaryFormat())
+	if err != nil {
+		t.Fatalf("creating HIBP: %v", err)
+	}
+
+	if err := h.datasets[ModeSHA1].store.Save("5BAA6", "etag", []byte(rangeData)); err != nil {
+		t.Fatalf("saving range: %v", err)
+	}
+
+	count, found, err := h.CheckPassword("password")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !found || count != 10434004 {
+		t.Fatalf("unexpected result: found=%v, count=%d", found, count)
+	}
+
+	_, found, err = h.Lookup(context.Background(), "5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if found {
+		t.Fatalf("expected hash to not be found")
+	}
+
+	// Query still returns the text format
+	reader, err := h.Query("5BAA6")
+	if err != nil {
+		t.Fatalf("querying: %v", err)
+	}
+	defer reader.Close()
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("reading: %v", err)
+	}
+
+	if string(data) != rangeData {
+		t.Fatalf("unexpected data: %q", data)
+	}
+}
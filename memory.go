@@ -0,0 +1,97 @@
+package hibp
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+	syncPkg "sync"
+)
+
+// MemoryStorage is a Storage keeping all ranges in memory, e.g., for tests or ephemeral services syncing a subset of
+// ranges using SyncWithRanges.
+// Ranges are immutable once saved; replacing a range swaps it as a whole, so readers are never disturbed.
+type MemoryStorage struct {
+	lock   syncPkg.RWMutex
+	ranges map[string]*memoryRange // prefix -> range
+}
+
+type memoryRange struct {
+	etag string
+	data []byte
+}
+
+var (
+	_ Storage = (*MemoryStorage)(nil)
+	_ sizer   = (*MemoryStorage)(nil)
+)
+
+// NewMemoryStorage creates an empty in-memory storage.
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{
+		ranges: make(map[string]*memoryRange),
+	}
+}
+
+func (m *MemoryStorage) Save(key, etag string, data []byte) error {
+	r := &memoryRange{
+		etag: etag,
+		data: bytes.Clone(data),
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.ranges[strings.ToUpper(key)] = r
+
+	return nil
+}
+
+func (m *MemoryStorage) LoadETag(key string) (string, error) {
+	r, err := m.load(key)
+	if err != nil {
+		return "", err
+	}
+
+	return r.etag, nil
+}
+
+func (m *MemoryStorage) LoadData(key string) (io.ReadCloser, error) {
+	r, err := m.load(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return io.NopCloser(bytes.NewReader(r.data)), nil
+}
+
+// Size returns the number of bytes of the range's data.
+func (m *MemoryStorage) Size(key string) (int64, error) {
+	r, err := m.load(key)
+	if err != nil {
+		return 0, err
+	}
+
+	return int64(len(r.data)), nil
+}
+
+// Len returns the number of ranges stored.
+func (m *MemoryStorage) Len() int {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	return len(m.ranges)
+}
+
+func (m *MemoryStorage) load(key string) (*memoryRange, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	r, exists := m.ranges[strings.ToUpper(key)]
+	if !exists {
+		return nil, fmt.Errorf("range %q: %w", key, fs.ErrNotExist)
+	}
+
+	return r, nil
+}
@@ -0,0 +1,61 @@
+package hibp
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestMemoryStorageWithoutTouchingDisk(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", "etag")
+		_, _ = w.Write([]byte("suffix:" + strings.TrimPrefix(r.URL.Path, "/range/")))
+	}))
+	defer server.Close()
+
+	dataDir := path.Join(t.TempDir(), "never-created")
+	store := NewMemoryStorage()
+
+	h, err := New(WithDataDir(dataDir), WithStorage(store))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := h.Sync(
+		SyncWithEndpoint(server.URL+"/range/"),
+		SyncWithRanges([]string{"0000A", "FFFFF"}),
+		SyncWithoutTrackingFailedRangesInFile(),
+		SyncWithoutTrackingMostRecentSuccessfulSyncInFile(),
+	); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if store.Len() != 2 {
+		t.Fatalf("expected 2 ranges, got %d", store.Len())
+	}
+
+	reader, err := h.Query("0000a")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer reader.Close()
+
+	data, err := io.ReadAll(reader)
+	if err != nil || string(data) != "suffix:0000A" {
+		t.Fatalf("unexpected data: %q, %v", data, err)
+	}
+
+	if _, err := h.Query("00000"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected missing range, got %v", err)
+	}
+
+	if _, err := os.Stat(dataDir); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected data dir to not be created, got %v", err)
+	}
+}
@@ -0,0 +1,185 @@
+package hibp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/alitto/pond"
+	"io/fs"
+	"slices"
+	"strings"
+	syncPkg "sync"
+)
+
+// Migrate copies all ranges together with their ETags from one storage into another, e.g., to convert an
+// uncompressed dataset into a compressed one or to move it to a different backend without downloading it again.
+// Ranges are copied in parallel; ranges missing in the source are skipped.
+// Ranges that cannot be copied do not stop the migration; they are reported using a *MigrateError once all other
+// ranges have been processed.
+// Unless disabled using MigrateWithoutVerification, all ranges of both storages are compared afterward, ranges
+// differing are reported as failures as well.
+// Using MigrateWithStateFile, an interrupted or failed migration continues from where it left off.
+// The source must not be modified during the migration.
+func Migrate(src, dst Storage, options ...MigrateOption) error {
+	config := &migrateConfig{
+		ctx:        context.Background(),
+		minWorkers: defaultWorkers,
+		lastRange:  defaultLastRange,
+		progressFn: func(_, _, _, _, _ int64) error { return nil },
+		verify:     true,
+	}
+
+	for _, option := range options {
+		option(config)
+	}
+
+	state := newSyncState()
+
+	if config.stateFile != nil {
+		var err error
+
+		state, err = readStateFile(config.stateFile)
+		if err != nil {
+			return fmt.Errorf("error reading state file: %w", err)
+		}
+
+		config.progressFn = wrapWithStateUpdate(state, config.stateFile, config.progressFn)
+	}
+
+	pool := pond.New(config.minWorkers, 0, pond.MinWorkers(config.minWorkers))
+
+	failures, ctxErr := processRanges(config.ctx, 0, config.lastRange+1, pool, state, config.progressFn, func(rangePrefix string) error {
+		return copyRange(src, dst, rangePrefix)
+	})
+
+	if ctxErr == nil && len(failures) == 0 {
+		// Ranges copied again after an interruption may leave garbage behind, e.g., in packs.
+		if c, ok := dst.(compacter); ok {
+			if err := c.Compact(); err != nil {
+				ctxErr = fmt.Errorf("compacting destination: %w", err)
+			}
+		}
+	}
+
+	if ctxErr == nil && len(failures) == 0 && config.verify {
+		failures, ctxErr = verifyMigration(config.ctx, src, dst, config.lastRange, config.minWorkers)
+
+		// Ranges that do not match have to be copied again by the next run
+		for _, failure := range failures {
+			if r, err := parseRangeString(failure.Prefix); err == nil {
+				state.markPending(r)
+			}
+		}
+	}
+
+	var migrateErr error
+
+	if len(failures) > 0 {
+		migrateErr = &MigrateError{Failures: failures}
+	}
+
+	if config.stateFile != nil {
+		if err := writeStateFile(config.stateFile, state); err != nil {
+			migrateErr = errors.Join(migrateErr, fmt.Errorf("persisting state: %w", err))
+		}
+	}
+
+	if ctxErr != nil {
+		return errors.Join(ctxErr, migrateErr)
+	}
+
+	return migrateErr
+}
+
+func copyRange(src, dst Storage, rangePrefix string) error {
+	etag, err := src.LoadETag(rangePrefix)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("loading etag: %w", err)
+	}
+
+	data, err := loadRangeData(src, rangePrefix)
+	if err != nil {
+		return fmt.Errorf("loading data: %w", err)
+	}
+
+	if err := dst.Save(rangePrefix, etag, data); err != nil {
+		return fmt.Errorf("saving range: %w", err)
+	}
+
+	return nil
+}
+
+// verifyMigration compares all ranges of both storages, ranges missing in the source must be missing in the destination
+// as well.
+func verifyMigration(ctx context.Context, src, dst Storage, lastRange int64, minWorkers int) ([]*RangeError, error) {
+	var (
+		failures []*RangeError
+		lock     syncPkg.Mutex
+	)
+
+	pool := pond.New(minWorkers, 0, pond.MinWorkers(minWorkers))
+
+	for i := int64(0); i <= lastRange; i++ {
+		if err := ctx.Err(); err != nil {
+			pool.StopAndWait()
+
+			return nil, err
+		}
+
+		rangePrefix := toRangeString(i)
+
+		pool.Submit(func() {
+			if err := compareRange(src, dst, rangePrefix); err != nil {
+				lock.Lock()
+				defer lock.Unlock()
+
+				failures = append(failures, &RangeError{Prefix: rangePrefix, Err: fmt.Errorf("verifying range: %w", err)})
+			}
+		})
+	}
+
+	pool.StopAndWait()
+
+	slices.SortFunc(failures, func(a, b *RangeError) int {
+		return strings.Compare(a.Prefix, b.Prefix)
+	})
+
+	return failures, nil
+}
+
+func compareRange(src, dst Storage, rangePrefix string) error {
+	srcETag, srcErr := src.LoadETag(rangePrefix)
+	dstETag, dstErr := dst.LoadETag(rangePrefix)
+
+	switch {
+	case errors.Is(srcErr, fs.ErrNotExist) && errors.Is(dstErr, fs.ErrNotExist):
+		return nil
+	case errors.Is(srcErr, fs.ErrNotExist):
+		return errors.New("range exists in the destination only")
+	case srcErr != nil:
+		return fmt.Errorf("loading etag from the source: %w", srcErr)
+	case dstErr != nil:
+		return fmt.Errorf("loading etag from the destination: %w", dstErr)
+	case srcETag != dstETag:
+		return fmt.Errorf("etags differ: %q != %q", srcETag, dstETag)
+	}
+
+	srcData, err := loadRangeData(src, rangePrefix)
+	if err != nil {
+		return fmt.Errorf("loading data from the source: %w", err)
+	}
+
+	dstData, err := loadRangeData(dst, rangePrefix)
+	if err != nil {
+		return fmt.Errorf("loading data from the destination: %w", err)
+	}
+
+	if !bytes.Equal(srcData, dstData) {
+		return errors.New("data differs")
+	}
+
+	return nil
+}
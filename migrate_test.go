@@ -0,0 +1,133 @@
+package hibp
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"sync/atomic"
+	"testing"
+)
+
+func TestMigrate(t *testing.T) {
+	src, err := NewFSStorage(t.TempDir(), WithoutCompression())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for i, rangePrefix := range []string{"00000", "00002", "0000F"} {
+		if err := src.Save(rangePrefix, "etag-"+rangePrefix, []byte(fmt.Sprintf("ABC:1\r\nDEF:%d\r\n", i+2))); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	dst, err := NewFSStorage(t.TempDir(), WithBinaryFormat())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := Migrate(src, dst, MigrateWithLastRange(0x1F)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for i := int64(0); i <= 0x1F; i++ {
+		rangePrefix := toRangeString(i)
+
+		if err := compareRange(src, dst, rangePrefix); err != nil {
+			t.Fatalf("range %s: %v", rangePrefix, err)
+		}
+	}
+
+	if _, err := dst.LoadETag("00001"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected range missing in the source to be skipped, got %v", err)
+	}
+}
+
+func TestMigrateResumesFailedRanges(t *testing.T) {
+	src := NewMemoryStorage()
+
+	for i := int64(0); i <= 0x1F; i++ {
+		rangePrefix := toRangeString(i)
+
+		if err := src.Save(rangePrefix, "etag", []byte("ABC:"+rangePrefix)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	dst := &failingStorage{Storage: NewMemoryStorage(), failing: "00010"}
+
+	stateFile, err := os.Create(path.Join(t.TempDir(), "state"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer stateFile.Close()
+
+	err = Migrate(src, dst, MigrateWithLastRange(0x1F), MigrateWithStateFile(stateFile))
+
+	var migrateErr *MigrateError
+	if !errors.As(err, &migrateErr) || len(migrateErr.Failures) != 1 || migrateErr.Failures[0].Prefix != "00010" {
+		t.Fatalf("expected range 00010 to fail, got %v", err)
+	}
+
+	if saves := dst.saves.Load(); saves != 0x1F {
+		t.Fatalf("expected %d saves, got %d", 0x1F, saves)
+	}
+
+	dst.failing = ""
+	dst.saves.Store(0)
+
+	if _, err := stateFile.Seek(0, 0); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := Migrate(src, dst, MigrateWithLastRange(0x1F), MigrateWithStateFile(stateFile)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if saves := dst.saves.Load(); saves != 1 {
+		t.Fatalf("expected only the failed range to be copied again, got %d saves", saves)
+	}
+}
+
+func TestMigrateDetectsMismatches(t *testing.T) {
+	src := NewMemoryStorage()
+	dst := NewMemoryStorage()
+
+	if err := src.Save("00001", "etag", []byte("ABC:1")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// A range that exists in the destination only cannot be explained by the source
+	if err := dst.Save("00002", "etag", []byte("ABC:2")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	err := Migrate(src, dst, MigrateWithLastRange(0xF))
+
+	var migrateErr *MigrateError
+	if !errors.As(err, &migrateErr) || len(migrateErr.Failures) != 1 || migrateErr.Failures[0].Prefix != "00002" {
+		t.Fatalf("expected range 00002 to fail the verification, got %v", err)
+	}
+
+	if err := Migrate(src, dst, MigrateWithLastRange(0xF), MigrateWithoutVerification()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// failingStorage fails to save the configured range and counts all other saves.
+type failingStorage struct {
+	Storage
+	failing string
+	saves   atomic.Int64
+}
+
+func (s *failingStorage) Save(key, etag string, data []byte) error {
+	if key == s.failing {
+		return fmt.Errorf("saving %q failed on purpose", key)
+	}
+
+	s.saves.Add(1)
+
+	return s.Storage.Save(key, etag, data)
+}
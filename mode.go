@@ -0,0 +1,78 @@
+package hibp
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// HashMode selects the hash family of the Pwned Passwords dataset.
+// Upstream provides every password both as SHA-1 and as NTLM hash; both variants can be synced and queried
+// independently of each other.
+type HashMode int
+
+const (
+	// ModeSHA1 refers to the SHA-1 variant of the dataset, which is the default of the upstream API.
+	ModeSHA1 HashMode = iota
+	// ModeNTLM refers to the NTLM variant of the dataset (requested with "?mode=ntlm" upstream).
+	ModeNTLM
+)
+
+// ntlmSubDir is the directory, relative to the data dir, the NTLM dataset is stored in.
+// The SHA-1 dataset lives directly in the data dir to stay compatible with datasets created before NTLM was supported.
+const ntlmSubDir = "ntlm"
+
+var hashModes = []HashMode{ModeSHA1, ModeNTLM}
+
+// ParseHashMode parses the textual representation of a HashMode, i.e., "sha1" or "ntlm".
+func ParseHashMode(s string) (HashMode, error) {
+	for _, mode := range hashModes {
+		if strings.EqualFold(s, mode.String()) {
+			return mode, nil
+		}
+	}
+
+	return 0, fmt.Errorf("unknown hash mode %q", s)
+}
+
+func (m HashMode) String() string {
+	switch m {
+	case ModeSHA1:
+		return "sha1"
+	case ModeNTLM:
+		return "ntlm"
+	default:
+		return fmt.Sprintf("HashMode(%d)", int(m))
+	}
+}
+
+func (m HashMode) valid() bool {
+	return m == ModeSHA1 || m == ModeNTLM
+}
+
+// hashLength returns the length of the hex-encoded hashes of the given mode.
+func (m HashMode) hashLength() int {
+	if m == ModeNTLM {
+		return 32
+	}
+
+	return 40
+}
+
+// dataDir returns the directory the dataset of the given mode is stored in.
+func (m HashMode) dataDir(baseDir string) string {
+	if m == ModeNTLM {
+		return path.Join(baseDir, ntlmSubDir)
+	}
+
+	return baseDir
+}
+
+// queryParameter returns the query string that has to be appended to range requests against the upstream API.
+func (m HashMode) queryParameter() string {
+	if m == ModeNTLM {
+		return "?mode=ntlm"
+	}
+
+	return ""
+}
@@ -0,0 +1,6 @@
+//go:build !race
+
+package hibp
+
+// raceEnabled reports whether the race detector is enabled, which makes sync.Pool drop pooled items at random.
+const raceEnabled = false
@@ -17,8 +17,18 @@ import (
 type ProgressFunc func(lowest, current, to, processed, remaining int64) error
 
 type commonConfig struct {
-	dataDir       string
-	noCompression bool
+	dataDir         string
+	noCompression   bool
+	level           int
+	binaryFormat    bool
+	snapshots       bool
+	keepGenerations int
+	sharedLocks     bool
+	readOnly        bool
+	cacheSize       int64
+	mode            HashMode
+	storage         Storage
+	modeStorages    map[HashMode]Storage
 }
 
 type CommonOption func(config *commonConfig)
@@ -32,8 +42,8 @@ func WithDataDir(dataDir string) CommonOption {
 	}
 }
 
-// WithoutCompression disables compression when writing/reading the file-based database.
-// When the local dataset exists already, this can only be used if the dataset has been created with the same setting.
+// WithoutCompression disables compression when writing the file-based database.
+// Reading is not affected, every range file records whether it is compressed, so existing datasets keep working.
 // This seriously increases the amount of storage required.
 // Default: false
 func WithoutCompression() CommonOption {
@@ -42,14 +52,130 @@ func WithoutCompression() CommonOption {
 	}
 }
 
+// WithCompressionLevel sets the zstd compression level used when writing ranges to the file-based database.
+// Levels follow the scale of the zstd command line tool, i.e., higher levels compress better but slower; they are
+// mapped to the closest level supported by the encoder.
+// Reading is not affected, ranges are readable regardless of the level they have been written with.
+// Default: 3
+func WithCompressionLevel(level int) CommonOption {
+	return func(c *commonConfig) {
+		c.level = level
+	}
+}
+
+// WithBinaryFormat stores the ranges in a compact binary format instead of compressed text: suffixes are stored as
+// packed hex followed by their counts encoded as varints.
+// Looking up a single hash, e.g., using Lookup, is done using binary search without reading the whole range;
+// Query and Export still return the text format of the upstream API.
+// Ranges that cannot be represented in the binary format without altering them, e.g., because they are not sorted,
+// are rejected.
+// Existing ranges remain readable, they are converted to the binary format once they are replaced by subsequent syncs.
+// Default: false
+func WithBinaryFormat() CommonOption {
+	return func(c *commonConfig) {
+		c.binaryFormat = true
+	}
+}
+
+// WithSnapshots enables the snapshot mode of the file-based storage: a sync writes into a new generation of the
+// dataset, sharing unchanged ranges with the current one using hard links, and readers are flipped over atomically
+// once the sync completes.
+// Until then, readers see the previous generation as a whole instead of a mix of old and new ranges.
+// A failed or interrupted sync leaves its generation pending, the next sync continues it.
+// The given number of previous generations is kept, so HIBP.Rollback can restore them.
+// Existing datasets are moved into the first generation while holding the lock of the data dir, New fails with
+// ErrDataDirLocked if it is in use; the data dir must not be used without snapshots afterward.
+// Default: false
+func WithSnapshots(keep int) CommonOption {
+	return func(c *commonConfig) {
+		c.snapshots = true
+		c.keepGenerations = keep
+	}
+}
+
+// WithSharedLocks makes Export and Verify hold a shared lock of the data dir while reading, so the dataset cannot be
+// modified meanwhile, e.g., by a sync run by another process; such operations fail with ErrDataDirLocked instead,
+// and vice versa.
+// Operations modifying the data dir of the file-based storage, e.g., Sync or Import, always lock it exclusively.
+// Default: false
+func WithSharedLocks() CommonOption {
+	return func(c *commonConfig) {
+		c.sharedLocks = true
+	}
+}
+
+// WithReadOnly opens the local dataset read-only, e.g., for consumers only querying a data dir that is mounted
+// read-only and kept in sync by another process.
+// Nothing is written to the data dir, not even directories are created; operations modifying the dataset, e.g., Sync
+// or Import, fail with ErrReadOnly.
+// The timestamp of the most recent successful sync is read from the data dir whenever it changes.
+// Default: false
+func WithReadOnly() CommonOption {
+	return func(c *commonConfig) {
+		c.readOnly = true
+	}
+}
+
+// WithCache caches the decompressed data of recently used ranges in memory, up to the given number of bytes in total,
+// speeding up repeated queries of popular prefixes, e.g., by Query, Lookup or the Handler.
+// The cache is shared by both hash families; the least recently used ranges are dropped once it is full.
+// Ranges are dropped as well when they are replaced, including by other processes, as cached ranges are only served
+// as long as their file on disk has not changed.
+// Only the file-based storage is cached, see HIBP.CacheStats for statistics.
+// Default: 0; meaning no ranges are cached.
+func WithCache(maxBytes int64) CommonOption {
+	return func(c *commonConfig) {
+		c.cacheSize = maxBytes
+	}
+}
+
+// WithHashMode sets the hash family that is used by all operations unless specified otherwise per call,
+// e.g., using SyncWithMode or QueryWithMode.
+// The NTLM dataset is kept in the sub-directory "ntlm" of the data dir, next to the SHA-1 dataset.
+// Default: ModeSHA1
+func WithHashMode(mode HashMode) CommonOption {
+	return func(c *commonConfig) {
+		c.mode = mode
+	}
+}
+
+// WithStorage sets the storage backend for the hash family configured using WithHashMode.
+// The data dir is still used for metadata, e.g., the timestamp of the most recent successful sync.
+// Default: the file-based storage, see NewFSStorage
+func WithStorage(storage Storage) CommonOption {
+	return func(c *commonConfig) {
+		c.storage = storage
+	}
+}
+
+// WithModeStorage sets the storage backend for the given hash family.
+// It allows using custom backends for both hash families at the same time, see WithStorage.
+// Default: the file-based storage, see NewFSStorage
+func WithModeStorage(mode HashMode, storage Storage) CommonOption {
+	return func(c *commonConfig) {
+		if c.modeStorages == nil {
+			c.modeStorages = make(map[HashMode]Storage)
+		}
+
+		c.modeStorages[mode] = storage
+	}
+}
+
 type syncConfig struct {
 	ctx                                 context.Context
 	endpoint                            string
+	mode                                HashMode
 	minWorkers                          int
 	progressFn                          ProgressFunc
+	resultFn                            func(SyncResult)
+	changeLog                           io.Writer
 	stateFile                           io.ReadWriteSeeker
 	lastRange                           int64
+	ranges                              []string
 	trackMostRecentSuccessfulSyncInFile bool
+	trackFailedRangesInFile             bool
+	ignoreETags                         bool
+	atomic                              bool
 }
 
 // SyncOption represents a type of function that can be used to customize the behavior of the Sync function.
@@ -70,6 +196,14 @@ func SyncWithEndpoint(endpoint string) SyncOption {
 	}
 }
 
+// SyncWithMode sets the hash family that should be synced.
+// Default: the mode configured using WithHashMode
+func SyncWithMode(mode HashMode) SyncOption {
+	return func(c *syncConfig) {
+		c.mode = mode
+	}
+}
+
 // SyncWithMinWorkers sets the minimum number of workers goroutines that will be used to process the ranges.
 // Default: 50
 func SyncWithMinWorkers(workers int) SyncOption {
@@ -79,6 +213,10 @@ func SyncWithMinWorkers(workers int) SyncOption {
 }
 
 // SyncWithStateFile sets the state file to be used for tracking progress.
+// The state records the completion of every single range as well as the ranges that failed permanently, a restarted
+// sync therefore only processes the ranges that have not been completed yet.
+// State files written by previous versions of this library, which only contain a single number, are migrated
+// automatically.
 // This can either be an os.File or any other implementation of io.ReadWriteSeeker.
 // Seeking is only used to jump back to the start of the "virtual file".
 // It should be easy enough to decorate a bytes.Buffer with the necessary methods to make it work.
@@ -99,6 +237,44 @@ func SyncWithProgressFn(progressFn ProgressFunc) SyncOption {
 	}
 }
 
+// SyncWithResultFn sets a function that receives the statistics of the sync once it has finished.
+// The function is called exactly once per sync as soon as the ranges have been processed, regardless of whether the
+// sync has been successful or not.
+// Default: no-op function
+func SyncWithResultFn(resultFn func(SyncResult)) SyncOption {
+	return func(c *syncConfig) {
+		c.resultFn = resultFn
+	}
+}
+
+// SyncWithChangeLog records the changes of all updated ranges to the given writer.
+// For every updated range, the previous content is compared with the new one before it gets replaced.
+// Lines have the schema "+<hash>:<count>" for added hashes, "-<hash>:<count>" for removed hashes and
+// "~<hash>:<old count>:<new count>" for hashes whose count has changed.
+// The writer is used concurrently by the workers, but access to it is serialized.
+// The changes of a range are recorded before it gets replaced: if writing them fails, the range is left as is and the
+// sync is aborted, so syncing again records them; if replacing the range fails, its changes are recorded nonetheless.
+// Note, recording changes requires reading every range before replacing it, which slows down syncing.
+// Default: nil; meaning no changes will be recorded.
+func SyncWithChangeLog(w io.Writer) SyncOption {
+	return func(c *syncConfig) {
+		c.changeLog = w
+	}
+}
+
+// SyncAtomically applies all changes of the sync at once when it succeeds; if it fails or gets cancelled, none of them
+// are applied.
+// This requires a storage supporting transactions spanning a whole sync, e.g., the SQLite storage provided by the
+// package "storage/sqlite"; other storages are rejected.
+// Atomic syncs cannot be combined with a state file, as progress is lost when the sync does not complete.
+// Note, changes recorded using SyncWithChangeLog are written as they happen, even if they get discarded later on.
+// Default: false
+func SyncAtomically() SyncOption {
+	return func(c *syncConfig) {
+		c.atomic = true
+	}
+}
+
 // SyncWithLastRange sets the last range to be processed.
 // Aside from tests, this is rarely useful.
 // Default: 0xFFFFF
@@ -116,3 +292,285 @@ func SyncWithoutTrackingMostRecentSuccessfulSyncInFile() SyncOption {
 		c.trackMostRecentSuccessfulSyncInFile = false
 	}
 }
+
+type queryConfig struct {
+	mode    HashMode
+	padding bool
+}
+
+// QueryOption represents a type of function that can be used to customize the behavior of the Query function.
+type QueryOption func(config *queryConfig)
+
+// QueryWithMode sets the hash family that should be queried.
+// Default: the mode configured using WithHashMode
+func QueryWithMode(mode HashMode) QueryOption {
+	return func(c *queryConfig) {
+		c.mode = mode
+	}
+}
+
+// QueryWithPadding pads the result with random entries having a count of 0, mimicking the "Add-Padding" header of
+// the upstream API.
+// The result will contain between 800 and 1,000 entries, hiding the actual size of the range from observers.
+// Padding entries are placed at their sorted position.
+// Default: false
+func QueryWithPadding() QueryOption {
+	return func(c *queryConfig) {
+		c.padding = true
+	}
+}
+
+type exportConfig struct {
+	mode HashMode
+}
+
+// ExportOption represents a type of function that can be used to customize the behavior of the Export function.
+type ExportOption func(config *exportConfig)
+
+// ExportWithMode sets the hash family that should be exported.
+// Default: the mode configured using WithHashMode
+func ExportWithMode(mode HashMode) ExportOption {
+	return func(c *exportConfig) {
+		c.mode = mode
+	}
+}
+
+// SyncWithRanges restricts the sync to the given ranges, e.g., to the ones reported by a SyncError.
+// A sync restricted to a set of ranges only updates the timestamp of the most recent successful sync if it completes
+// the set of ranges that failed previously, see HIBP.RetryFailed.
+// It cannot be combined with SyncWithStateFile.
+// Default: nil; meaning all ranges will be synced.
+func SyncWithRanges(ranges []string) SyncOption {
+	return func(c *syncConfig) {
+		c.ranges = ranges
+	}
+}
+
+// syncWithoutETags disables conditional requests, i.e., every range gets downloaded and written regardless of whether
+// it has changed.
+func syncWithoutETags() SyncOption {
+	return func(c *syncConfig) {
+		c.ignoreETags = true
+	}
+}
+
+// SyncWithoutTrackingFailedRangesInFile disables tracking the ranges that failed permanently in a file.
+// The file is placed in the data dir and used by HIBP.RetryFailed to re-request only those ranges.
+// Default: creating a file for the failed ranges is enabled
+func SyncWithoutTrackingFailedRangesInFile() SyncOption {
+	return func(c *syncConfig) {
+		c.trackFailedRangesInFile = false
+	}
+}
+
+type importConfig struct {
+	ctx        context.Context
+	mode       HashMode
+	minWorkers int
+}
+
+// ImportOption represents a type of function that can be used to customize the behavior of the Import function.
+type ImportOption func(config *importConfig)
+
+// ImportWithContext sets the context for the import operation.
+func ImportWithContext(ctx context.Context) ImportOption {
+	return func(c *importConfig) {
+		c.ctx = ctx
+	}
+}
+
+// ImportWithMode sets the hash family of the imported dataset.
+// Default: the mode configured using WithHashMode
+func ImportWithMode(mode HashMode) ImportOption {
+	return func(c *importConfig) {
+		c.mode = mode
+	}
+}
+
+// ImportWithMinWorkers sets the minimum number of workers goroutines that will be used to store the ranges.
+// Default: 50
+func ImportWithMinWorkers(workers int) ImportOption {
+	return func(c *importConfig) {
+		c.minWorkers = workers
+	}
+}
+
+type verifyConfig struct {
+	mode        HashMode
+	minWorkers  int
+	lastRange   int64
+	repair      bool
+	syncOptions []SyncOption
+}
+
+// VerifyOption represents a type of function that can be used to customize the behavior of the Verify function.
+type VerifyOption func(config *verifyConfig)
+
+// VerifyWithMode sets the hash family that should be verified.
+// Default: the mode configured using WithHashMode
+func VerifyWithMode(mode HashMode) VerifyOption {
+	return func(c *verifyConfig) {
+		c.mode = mode
+	}
+}
+
+// VerifyWithMinWorkers sets the minimum number of workers goroutines that will be used to check the ranges.
+// Default: 50
+func VerifyWithMinWorkers(workers int) VerifyOption {
+	return func(c *verifyConfig) {
+		c.minWorkers = workers
+	}
+}
+
+// VerifyWithLastRange sets the last range to be checked.
+// Aside from tests, this is rarely useful.
+// Default: 0xFFFFF
+func VerifyWithLastRange(to int64) VerifyOption {
+	return func(c *verifyConfig) {
+		c.lastRange = to
+	}
+}
+
+// VerifyWithRepair repairs the problems found: temporary files are removed, missing and corrupt ranges are fetched
+// from upstream again.
+// The given options are passed on to Sync, e.g., to use a custom endpoint.
+// Default: problems are only reported
+func VerifyWithRepair(syncOptions ...SyncOption) VerifyOption {
+	return func(c *verifyConfig) {
+		c.repair = true
+		c.syncOptions = syncOptions
+	}
+}
+
+type trainConfig struct {
+	mode       HashMode
+	samples    int
+	maxSize    int
+	recompress bool
+	minWorkers int
+}
+
+// TrainOption represents a type of function that can be used to customize the behavior of the TrainDictionary
+// function.
+type TrainOption func(config *trainConfig)
+
+// TrainWithMode sets the hash family whose ranges the dictionary is trained on and used for.
+// Default: the mode configured using WithHashMode
+func TrainWithMode(mode HashMode) TrainOption {
+	return func(c *trainConfig) {
+		c.mode = mode
+	}
+}
+
+// TrainWithSamples sets the number of ranges the dictionary is trained on, they are spread evenly across the dataset.
+// Default: 2000
+func TrainWithSamples(samples int) TrainOption {
+	return func(c *trainConfig) {
+		c.samples = samples
+	}
+}
+
+// TrainWithMaxSize sets the maximum size of the dictionary in bytes.
+// Default: 112640
+func TrainWithMaxSize(maxSize int) TrainOption {
+	return func(c *trainConfig) {
+		c.maxSize = maxSize
+	}
+}
+
+// TrainWithRecompression rewrites all existing ranges using the new dictionary.
+// Otherwise, ranges make use of the dictionary once they are replaced by subsequent syncs.
+// Recompressing must not run concurrently with a sync of the same hash family.
+// Default: false
+func TrainWithRecompression() TrainOption {
+	return func(c *trainConfig) {
+		c.recompress = true
+	}
+}
+
+// TrainWithMinWorkers sets the minimum number of workers goroutines that will be used to recompress the ranges.
+// Default: 50
+func TrainWithMinWorkers(workers int) TrainOption {
+	return func(c *trainConfig) {
+		c.minWorkers = workers
+	}
+}
+
+type migrateConfig struct {
+	ctx        context.Context
+	minWorkers int
+	lastRange  int64
+	stateFile  io.ReadWriteSeeker
+	progressFn ProgressFunc
+	verify     bool
+}
+
+// MigrateOption represents a type of function that can be used to customize the behavior of the Migrate function.
+type MigrateOption func(config *migrateConfig)
+
+// MigrateWithContext sets the context for the migration.
+func MigrateWithContext(ctx context.Context) MigrateOption {
+	return func(c *migrateConfig) {
+		c.ctx = ctx
+	}
+}
+
+// MigrateWithMinWorkers sets the minimum number of workers goroutines that will be used to copy and verify the ranges.
+// Default: 50
+func MigrateWithMinWorkers(workers int) MigrateOption {
+	return func(c *migrateConfig) {
+		c.minWorkers = workers
+	}
+}
+
+// MigrateWithLastRange sets the last range to be migrated.
+// Aside from tests, this is rarely useful.
+// Default: 0xFFFFF
+func MigrateWithLastRange(to int64) MigrateOption {
+	return func(c *migrateConfig) {
+		c.lastRange = to
+	}
+}
+
+// MigrateWithStateFile sets the state file to be used for tracking progress, it uses the same format as
+// SyncWithStateFile.
+// A restarted migration only copies the ranges that have not been copied yet.
+// Default: nil; meaning no state will be tracked.
+func MigrateWithStateFile(stateFile io.ReadWriteSeeker) MigrateOption {
+	return func(c *migrateConfig) {
+		c.stateFile = stateFile
+	}
+}
+
+// MigrateWithProgressFn sets a custom progress function that will be called regularly while copying the ranges.
+// The function should return an error if the operation should be aborted.
+// Note, there is no guarantee that the function will be called for every prefix.
+// Default: no-op function
+func MigrateWithProgressFn(progressFn ProgressFunc) MigrateOption {
+	return func(c *migrateConfig) {
+		c.progressFn = progressFn
+	}
+}
+
+// MigrateWithoutVerification skips comparing all ranges of both storages once they have been copied.
+// Default: the result is verified
+func MigrateWithoutVerification() MigrateOption {
+	return func(c *migrateConfig) {
+		c.verify = false
+	}
+}
+
+type rollbackConfig struct {
+	mode HashMode
+}
+
+// RollbackOption represents a type of function that can be used to customize the behavior of the Rollback function.
+type RollbackOption func(config *rollbackConfig)
+
+// RollbackWithMode sets the hash family that should be rolled back.
+// Default: the mode configured using WithHashMode
+func RollbackWithMode(mode HashMode) RollbackOption {
+	return func(c *rollbackConfig) {
+		c.mode = mode
+	}
+}
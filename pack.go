@@ -0,0 +1,499 @@
+package hibp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/klauspost/compress/zstd"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	syncPkg "sync"
+)
+
+// The pack storage keeps all ranges in a single, append-only pack file accompanied by an index with one fixed-size
+// entry per range:
+//
+//	offset in the pack file (uint64) | length (uint32) | CRC-32 of the range (uint32)
+//
+// A length of 0 denotes a missing range. All integers are encoded in big endian byte order.
+// Every range is compressed individually, containing the ETag followed by the data - just like the files of the
+// file-based storage.
+// Replacing a range appends its new version to the pack and updates its index entry; the previous version remains
+// as garbage until the pack gets compacted.
+// Compaction writes a new generation of pack and index, which is activated by atomically replacing the file
+// "CURRENT" that names the generation in use.
+const (
+	packCurrentFileName = "CURRENT"
+	packIndexEntrySize  = 16
+)
+
+type packEntry struct {
+	offset uint64
+	length uint32
+	crc    uint32
+}
+
+// PackStorage is a Storage keeping all ranges in a single pack file instead of one file per range.
+// This avoids running out of inodes and speeds up backups, but requires compaction after ranges have been replaced,
+// see Compact.
+type PackStorage struct {
+	dir string
+	enc *zstd.Encoder
+	dec *zstd.Decoder
+
+	// genLock guards the generation, i.e., the files in use; it is held exclusively only while compacting.
+	genLock    syncPkg.RWMutex
+	generation int
+	pack       *os.File
+	index      *os.File
+	// appendLock serializes reserving space at the end of the pack.
+	appendLock syncPkg.Mutex
+	packSize   uint64
+	// entriesLock guards the in-memory copy of the index as well as the index file.
+	entriesLock syncPkg.Mutex
+	entries     []packEntry
+}
+
+// compacter is implemented by storages that need to drop replaced ranges after syncing.
+type compacter interface {
+	Compact() error
+}
+
+var (
+	_ Storage   = (*PackStorage)(nil)
+	_ sizer     = (*PackStorage)(nil)
+	_ compacter = (*PackStorage)(nil)
+)
+
+// NewPackStorage opens the pack storage in the given directory, creating it if it does not exist.
+// The storage has to be closed using Close.
+func NewPackStorage(dir string) (*PackStorage, error) {
+	enc, err := zstd.NewWriter(nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating zstd writer: %w", err)
+	}
+
+	dec, err := zstd.NewReader(nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating zstd reader: %w", err)
+	}
+
+	p := &PackStorage{
+		dir: dir,
+		enc: enc,
+		dec: dec,
+	}
+
+	if err := os.MkdirAll(dir, dirMode); err != nil {
+		return nil, fmt.Errorf("creating directory %q: %w", dir, err)
+	}
+
+	generation, err := p.readCurrentGeneration()
+	if err != nil {
+		return nil, err
+	}
+
+	if generation == 0 {
+		generation = 1
+
+		if err := p.writeGeneration(generation, func(_ io.Writer, _ []packEntry) error { return nil }); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := p.openGeneration(generation); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+func (p *PackStorage) Save(key, etag string, data []byte) error {
+	i, err := packIndex(key)
+	if err != nil {
+		return err
+	}
+
+	raw := make([]byte, 0, len(etag)+1+len(data))
+	raw = append(raw, etag...)
+	raw = append(raw, '\n')
+	raw = append(raw, data...)
+
+	blob := p.enc.EncodeAll(raw, nil)
+
+	p.genLock.RLock()
+	defer p.genLock.RUnlock()
+
+	p.appendLock.Lock()
+	offset := p.packSize
+	p.packSize += uint64(len(blob))
+	p.appendLock.Unlock()
+
+	if _, err := p.pack.WriteAt(blob, int64(offset)); err != nil {
+		return fmt.Errorf("appending range %q to pack: %w", key, err)
+	}
+
+	// The range must be on stable storage before the index refers to it
+	if err := p.pack.Sync(); err != nil {
+		return fmt.Errorf("syncing pack to stable storage: %w", err)
+	}
+
+	entry := packEntry{
+		offset: offset,
+		length: uint32(len(blob)),
+		crc:    crc32.ChecksumIEEE(blob),
+	}
+
+	p.entriesLock.Lock()
+	defer p.entriesLock.Unlock()
+
+	if _, err := p.index.WriteAt(entry.encode(), int64(i)*packIndexEntrySize); err != nil {
+		return fmt.Errorf("updating index entry of range %q: %w", key, err)
+	}
+
+	p.entries[i] = entry
+
+	return nil
+}
+
+func (p *PackStorage) LoadETag(key string) (string, error) {
+	raw, err := p.load(key)
+	if err != nil {
+		return "", err
+	}
+
+	etag, _, found := bytes.Cut(raw, []byte("\n"))
+	if !found {
+		return "", fmt.Errorf("range %q is missing its etag", key)
+	}
+
+	return string(etag), nil
+}
+
+// LoadData returns the data of the range.
+// The range is read into memory as a whole, so replacing it does not affect the returned reader.
+func (p *PackStorage) LoadData(key string) (io.ReadCloser, error) {
+	raw, err := p.load(key)
+	if err != nil {
+		return nil, err
+	}
+
+	_, data, found := bytes.Cut(raw, []byte("\n"))
+	if !found {
+		return nil, fmt.Errorf("range %q is missing its etag", key)
+	}
+
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (p *PackStorage) Size(key string) (int64, error) {
+	i, err := packIndex(key)
+	if err != nil {
+		return 0, err
+	}
+
+	p.entriesLock.Lock()
+	entry := p.entries[i]
+	p.entriesLock.Unlock()
+
+	if entry.length == 0 {
+		return 0, fmt.Errorf("range %q: %w", key, fs.ErrNotExist)
+	}
+
+	return int64(entry.length), nil
+}
+
+// Compact rewrites the pack, dropping all versions of ranges that have been replaced.
+// The new pack is activated atomically, concurrent operations are blocked while compacting.
+// Compact is a no-op if there is nothing to drop.
+func (p *PackStorage) Compact() error {
+	p.genLock.Lock()
+	defer p.genLock.Unlock()
+
+	var live uint64
+	for _, entry := range p.entries {
+		live += uint64(entry.length)
+	}
+
+	if live == p.packSize {
+		return nil
+	}
+
+	generation := p.generation + 1
+
+	err := p.writeGeneration(generation, func(w io.Writer, entries []packEntry) error {
+		var offset uint64
+
+		for i, entry := range p.entries {
+			if entry.length == 0 {
+				continue
+			}
+
+			blob, err := p.readBlob(entry)
+			if err != nil {
+				return fmt.Errorf("reading range %q: %w", toRangeString(int64(i)), err)
+			}
+
+			if _, err := w.Write(blob); err != nil {
+				return fmt.Errorf("writing range %q: %w", toRangeString(int64(i)), err)
+			}
+
+			entries[i] = packEntry{offset: offset, length: entry.length, crc: entry.crc}
+			offset += uint64(entry.length)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("compacting pack: %w", err)
+	}
+
+	previousGeneration := p.generation
+
+	if err := p.closeFiles(); err != nil {
+		return err
+	}
+
+	if err := p.openGeneration(generation); err != nil {
+		return err
+	}
+
+	// The previous generation is not in use anymore, failing to remove it only wastes space
+	_ = os.Remove(p.packPath(previousGeneration))
+	_ = os.Remove(p.indexPath(previousGeneration))
+
+	return nil
+}
+
+// Close closes the files of the storage.
+func (p *PackStorage) Close() error {
+	p.genLock.Lock()
+	defer p.genLock.Unlock()
+
+	p.dec.Close()
+
+	return errors.Join(p.enc.Close(), p.closeFiles())
+}
+
+func (p *PackStorage) load(key string) ([]byte, error) {
+	i, err := packIndex(key)
+	if err != nil {
+		return nil, err
+	}
+
+	p.genLock.RLock()
+	defer p.genLock.RUnlock()
+
+	p.entriesLock.Lock()
+	entry := p.entries[i]
+	p.entriesLock.Unlock()
+
+	if entry.length == 0 {
+		return nil, fmt.Errorf("range %q: %w", key, fs.ErrNotExist)
+	}
+
+	blob, err := p.readBlob(entry)
+	if err != nil {
+		return nil, fmt.Errorf("reading range %q: %w", key, err)
+	}
+
+	raw, err := p.dec.DecodeAll(blob, nil)
+	if err != nil {
+		return nil, fmt.Errorf("decompressing range %q: %w", key, err)
+	}
+
+	return raw, nil
+}
+
+func (p *PackStorage) readBlob(entry packEntry) ([]byte, error) {
+	blob := make([]byte, entry.length)
+
+	if _, err := p.pack.ReadAt(blob, int64(entry.offset)); err != nil {
+		return nil, err
+	}
+
+	if crc32.ChecksumIEEE(blob) != entry.crc {
+		return nil, errors.New("checksum mismatch")
+	}
+
+	return blob, nil
+}
+
+func (p *PackStorage) readCurrentGeneration() (int, error) {
+	current, err := os.ReadFile(path.Join(p.dir, packCurrentFileName))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return 0, nil
+		}
+
+		return 0, fmt.Errorf("reading current generation: %w", err)
+	}
+
+	generation, err := strconv.Atoi(strings.TrimSpace(string(current)))
+	if err != nil {
+		return 0, fmt.Errorf("parsing current generation %q: %w", current, err)
+	}
+
+	return generation, nil
+}
+
+// writeGeneration creates pack and index of the given generation and activates it.
+// writePack writes the ranges to the pack and fills in their index entries.
+func (p *PackStorage) writeGeneration(generation int, writePack func(w io.Writer, entries []packEntry) error) error {
+	entries := make([]packEntry, numRanges)
+
+	if err := writeFileSynced(p.packPath(generation), func(w io.Writer) error {
+		return writePack(w, entries)
+	}); err != nil {
+		return err
+	}
+
+	if err := writeFileSynced(p.indexPath(generation), func(w io.Writer) error {
+		for _, entry := range entries {
+			if _, err := w.Write(entry.encode()); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	currentPath := path.Join(p.dir, packCurrentFileName)
+
+	if err := writeFileSynced(currentPath+tmpSuffix, func(w io.Writer) error {
+		_, err := io.WriteString(w, strconv.Itoa(generation))
+		return err
+	}); err != nil {
+		return err
+	}
+
+	// Replaces the pointer to the current generation; on unix-like systems that should be an atomic operation
+	if err := os.Rename(currentPath+tmpSuffix, currentPath); err != nil {
+		return fmt.Errorf("activating generation %d: %w", generation, err)
+	}
+
+	return nil
+}
+
+func (p *PackStorage) openGeneration(generation int) error {
+	pack, err := os.OpenFile(p.packPath(generation), os.O_RDWR, 0)
+	if err != nil {
+		return fmt.Errorf("opening pack: %w", err)
+	}
+
+	index, err := os.OpenFile(p.indexPath(generation), os.O_RDWR, 0)
+	if err != nil {
+		_ = pack.Close()
+		return fmt.Errorf("opening index: %w", err)
+	}
+
+	rawIndex, err := io.ReadAll(index)
+	if err != nil {
+		_ = pack.Close()
+		_ = index.Close()
+
+		return fmt.Errorf("reading index: %w", err)
+	}
+
+	if len(rawIndex) != numRanges*packIndexEntrySize {
+		_ = pack.Close()
+		_ = index.Close()
+
+		return fmt.Errorf("index has %d bytes, expected %d", len(rawIndex), numRanges*packIndexEntrySize)
+	}
+
+	info, err := pack.Stat()
+	if err != nil {
+		_ = pack.Close()
+		_ = index.Close()
+
+		return fmt.Errorf("getting size of pack: %w", err)
+	}
+
+	entries := make([]packEntry, numRanges)
+	for i := range entries {
+		entries[i] = decodePackEntry(rawIndex[i*packIndexEntrySize:])
+	}
+
+	p.generation = generation
+	p.pack = pack
+	p.index = index
+	p.entries = entries
+	// Anything following the last range that made it into the index, e.g., due to a crash, is garbage that gets
+	// dropped with the next compaction.
+	p.packSize = uint64(info.Size())
+
+	return nil
+}
+
+func (p *PackStorage) closeFiles() error {
+	return errors.Join(p.pack.Close(), p.index.Close())
+}
+
+func (p *PackStorage) packPath(generation int) string {
+	return path.Join(p.dir, fmt.Sprintf("%08d.pack", generation))
+}
+
+func (p *PackStorage) indexPath(generation int) string {
+	return path.Join(p.dir, fmt.Sprintf("%08d.idx", generation))
+}
+
+func (e packEntry) encode() []byte {
+	buf := make([]byte, packIndexEntrySize)
+	binary.BigEndian.PutUint64(buf, e.offset)
+	binary.BigEndian.PutUint32(buf[8:], e.length)
+	binary.BigEndian.PutUint32(buf[12:], e.crc)
+
+	return buf
+}
+
+func decodePackEntry(buf []byte) packEntry {
+	return packEntry{
+		offset: binary.BigEndian.Uint64(buf),
+		length: binary.BigEndian.Uint32(buf[8:]),
+		crc:    binary.BigEndian.Uint32(buf[12:]),
+	}
+}
+
+func packIndex(key string) (int64, error) {
+	i, err := parseRangeString(strings.ToUpper(key))
+	if err != nil {
+		return 0, err
+	}
+
+	return i, nil
+}
+
+// writeFileSynced writes a file and ensures it is on stable storage before returning.
+func writeFileSynced(filePath string, write func(w io.Writer) error) error {
+	file, err := os.Create(filePath)
+	if err != nil {
+		return fmt.Errorf("creating file %q: %w", filePath, err)
+	}
+	defer file.Close()
+
+	bufWriter := bufio.NewWriterSize(file, 1024*1024)
+
+	if err := write(bufWriter); err != nil {
+		return fmt.Errorf("writing file %q: %w", filePath, err)
+	}
+
+	if err := bufWriter.Flush(); err != nil {
+		return fmt.Errorf("writing file %q: %w", filePath, err)
+	}
+
+	if err := file.Sync(); err != nil {
+		return fmt.Errorf("syncing file %q to stable storage: %w", filePath, err)
+	}
+
+	return file.Close()
+}
@@ -0,0 +1,153 @@
+package hibp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestPackStorageCompact(t *testing.T) {
+	dir := t.TempDir()
+
+	store, err := NewPackStorage(dir)
+	if err != nil {
+		t.Fatalf("creating storage: %v", err)
+	}
+
+	for _, etag := range []string{"etag1", "etag2", "etag3"} {
+		if err := store.Save("00000", etag, []byte("data of "+etag)); err != nil {
+			t.Fatalf("saving range: %v", err)
+		}
+	}
+
+	if err := store.Save("FFFFF", "etag", []byte("last")); err != nil {
+		t.Fatalf("saving range: %v", err)
+	}
+
+	sizeBefore := fileSize(t, store.packPath(1))
+
+	if err := store.Compact(); err != nil {
+		t.Fatalf("compacting: %v", err)
+	}
+
+	if sizeAfter := fileSize(t, store.packPath(2)); sizeAfter >= sizeBefore {
+		t.Fatalf("expected compaction to shrink the pack, got %d bytes before and %d after", sizeBefore, sizeAfter)
+	}
+
+	if _, err := os.Stat(store.packPath(1)); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected previous pack to be removed, got %v", err)
+	}
+
+	if err := store.Close(); err != nil {
+		t.Fatalf("closing storage: %v", err)
+	}
+
+	// Reopening must pick up the compacted generation
+	store, err = NewPackStorage(dir)
+	if err != nil {
+		t.Fatalf("reopening storage: %v", err)
+	}
+	defer store.Close()
+
+	if store.generation != 2 {
+		t.Fatalf("expected generation 2, got %d", store.generation)
+	}
+
+	for key, expected := range map[string]string{"00000": "data of etag3", "FFFFF": "last"} {
+		reader, err := store.LoadData(key)
+		if err != nil {
+			t.Fatalf("loading range %q: %v", key, err)
+		}
+
+		data, err := io.ReadAll(reader)
+		if err != nil {
+			t.Fatalf("reading range %q: %v", key, err)
+		}
+
+		if string(data) != expected {
+			t.Fatalf("expected %q for range %q, got %q", expected, key, data)
+		}
+	}
+
+	if _, err := store.LoadETag("00001"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected missing range, got %v", err)
+	}
+
+	// Nothing to drop, so no new generation
+	if err := store.Compact(); err != nil {
+		t.Fatalf("compacting: %v", err)
+	}
+
+	if store.generation != 2 {
+		t.Fatalf("expected generation 2 after no-op compaction, got %d", store.generation)
+	}
+}
+
+func TestPackStorageDetectsCorruption(t *testing.T) {
+	dir := t.TempDir()
+
+	store, err := NewPackStorage(dir)
+	if err != nil {
+		t.Fatalf("creating storage: %v", err)
+	}
+	defer store.Close()
+
+	if err := store.Save("00000", "etag", []byte("data")); err != nil {
+		t.Fatalf("saving range: %v", err)
+	}
+
+	if _, err := store.pack.WriteAt([]byte{0xFF}, 0); err != nil {
+		t.Fatalf("corrupting pack: %v", err)
+	}
+
+	if _, err := store.LoadETag("00000"); err == nil {
+		t.Fatal("expected an error loading a corrupt range")
+	}
+}
+
+func TestPackStorageDetectsTruncatedIndex(t *testing.T) {
+	dir := t.TempDir()
+
+	store, err := NewPackStorage(dir)
+	if err != nil {
+		t.Fatalf("creating storage: %v", err)
+	}
+
+	if err := store.Save("00000", "etag", []byte("data")); err != nil {
+		t.Fatalf("saving range: %v", err)
+	}
+
+	indexPath := store.indexPath(store.generation)
+
+	if err := store.Close(); err != nil {
+		t.Fatalf("closing storage: %v", err)
+	}
+
+	if err := os.Truncate(indexPath, packIndexEntrySize); err != nil {
+		t.Fatalf("truncating index: %v", err)
+	}
+
+	_, err = NewPackStorage(dir)
+	if err == nil {
+		t.Fatal("expected an error opening a truncated index")
+	}
+
+	if expected := fmt.Sprintf("index has %d bytes, expected %d", packIndexEntrySize, numRanges*packIndexEntrySize); !strings.Contains(err.Error(), expected) {
+		t.Fatalf("expected the error to describe the size of the index, got %v", err)
+	}
+}
+
+func fileSize(t *testing.T, filePath string) int64 {
+	t.Helper()
+
+	info, err := os.Stat(filePath)
+	if err != nil {
+		t.Fatalf("stat %q: %v", filePath, err)
+	}
+
+	return info.Size()
+}
@@ -0,0 +1,66 @@
+package hibp
+
+import (
+	"bytes"
+	"math/rand"
+	"sort"
+)
+
+// The upstream API pads responses to contain between 800 and 1,000 entries when asked to do so using the
+// "Add-Padding" header, see https://haveibeenpwned.com/API/v3#PwnedPasswordsPadding.
+const (
+	minPaddedEntries = 800
+	maxPaddedEntries = 1000
+)
+
+const hexDigits = "0123456789ABCDEF"
+
+// padRange adds random entries with a count of 0 to the given range data until it contains a random number of
+// entries between minPaddedEntries and maxPaddedEntries.
+// The padding entries are placed at their sorted position, making them indistinguishable from the real entries
+// except for their count.
+// Ranges that are already large enough are returned unchanged.
+func padRange(data []byte, suffixLength int) []byte {
+	lines := bytes.Split(data, []byte("\n"))
+
+	existing := make(map[string]struct{}, len(lines))
+	entries := make([][]byte, 0, maxPaddedEntries)
+
+	for _, line := range lines {
+		line = bytes.TrimSuffix(line, []byte("\r"))
+		if len(line) == 0 {
+			continue
+		}
+
+		suffix, _, _ := bytes.Cut(line, []byte(":"))
+		existing[string(suffix)] = struct{}{}
+		entries = append(entries, line)
+	}
+
+	target := minPaddedEntries + rand.Intn(maxPaddedEntries-minPaddedEntries+1)
+	if len(entries) >= target {
+		return data
+	}
+
+	for len(entries) < target {
+		suffix := make([]byte, suffixLength)
+		for i := range suffix {
+			suffix[i] = hexDigits[rand.Intn(len(hexDigits))]
+		}
+
+		if _, exists := existing[string(suffix)]; exists {
+			continue
+		}
+
+		existing[string(suffix)] = struct{}{}
+		entries = append(entries, append(suffix, ":0"...))
+	}
+
+	// Suffixes have the same length and only consist of upper-case hex digits, so comparing the whole lines
+	// yields the order of the suffixes.
+	sort.Slice(entries, func(i, j int) bool {
+		return bytes.Compare(entries[i], entries[j]) < 0
+	})
+
+	return bytes.Join(entries, lineSeparator)
+}
@@ -0,0 +1,93 @@
+package hibp
+
+import (
+	"bytes"
+	"go.uber.org/mock/gomock"
+	"io"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestPadRange(t *testing.T) {
+	t.Run("pads small ranges", func(t *testing.T) {
+		data := []byte("0005AD76BD555C1D6D771DE417A4B87E4B4:10\r\n000A8DAE4228F821FB418F59826079BF368:4")
+
+		lines := strings.Split(string(padRange(data, 35)), "\r\n")
+
+		if len(lines) < minPaddedEntries || len(lines) > maxPaddedEntries {
+			t.Fatalf("unexpected number of lines: %d", len(lines))
+		}
+
+		if !sort.StringsAreSorted(lines) {
+			t.Fatalf("lines are not sorted")
+		}
+
+		seen := make(map[string]struct{}, len(lines))
+		padding := 0
+
+		for _, line := range lines {
+			suffix, count, _ := strings.Cut(line, ":")
+			if len(suffix) != 35 {
+				t.Fatalf("unexpected suffix length: %q", line)
+			}
+
+			if _, exists := seen[suffix]; exists {
+				t.Fatalf("duplicate suffix: %q", suffix)
+			}
+
+			seen[suffix] = struct{}{}
+
+			if count == "0" {
+				padding++
+			}
+		}
+
+		if padding != len(lines)-2 {
+			t.Fatalf("unexpected number of padding entries: %d", padding)
+		}
+	})
+
+	t.Run("leaves large ranges untouched", func(t *testing.T) {
+		lines := make([]string, 0, maxPaddedEntries)
+		for i := 0; i < maxPaddedEntries; i++ {
+			lines = append(lines, strings.Repeat("0", 30)+toRangeString(int64(i))+":1")
+		}
+
+		data := []byte(strings.Join(lines, "\r\n"))
+
+		if !bytes.Equal(padRange(data, 35), data) {
+			t.Fatalf("expected data to be unchanged")
+		}
+	})
+}
+
+func TestQueryWithPadding(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	storageMock := NewMockStorage(ctrl)
+
+	storageMock.EXPECT().LoadData("00000").Return(io.NopCloser(bytes.NewReader([]byte("0000000000000000000000000000000001A:3"))), nil)
+
+	h := &HIBP{datasets: map[HashMode]*dataset{ModeSHA1: {store: storageMock}}}
+
+	reader, err := h.Query("00000", QueryWithPadding())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer reader.Close()
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	lines := strings.Split(string(data), "\r\n")
+
+	if len(lines) < minPaddedEntries {
+		t.Fatalf("unexpected number of lines: %d", len(lines))
+	}
+
+	if !strings.Contains(string(data), "0000000000000000000000000000000001A:3") {
+		t.Fatalf("original entry is missing")
+	}
+}
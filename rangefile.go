@@ -0,0 +1,93 @@
+package hibp
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Range files of the file-based storage start with a header describing their format:
+//
+//	magic "HIBP" | format version (uint8) | codec (uint8)
+//
+// It is followed by the ETag, terminated by a newline, and the data of the range, both encoded using the codec.
+// Files written by earlier versions lack the header, their codec is detected by sniffing: zstd frames start with a
+// magic number of their own, everything else is considered plain text.
+// Therefore, datasets mixing codecs keep working, regardless of the options used for writing.
+const (
+	rangeFileMagic      = "HIBP"
+	rangeFileVersion    = 1
+	rangeFileHeaderSize = len(rangeFileMagic) + 2
+)
+
+// zstdMagic is the magic number every zstd frame starts with.
+var zstdMagic = []byte{0x28, 0xB5, 0x2F, 0xFD}
+
+type rangeCodec byte
+
+const (
+	codecPlain rangeCodec = iota
+	// codecZstd compresses ETag and data using zstd, possibly using the dictionary of the data dir.
+	codecZstd
+	// codecBinary stores the data in the binary range format, see encodeBinaryRange; the ETag is stored as plain text.
+	codecBinary
+)
+
+func (c rangeCodec) String() string {
+	switch c {
+	case codecPlain:
+		return "plain"
+	case codecZstd:
+		return "zstd"
+	case codecBinary:
+		return "binary"
+	default:
+		return fmt.Sprintf("rangeCodec(%d)", byte(c))
+	}
+}
+
+func (c rangeCodec) valid() bool {
+	return c <= codecBinary
+}
+
+func encodeRangeFileHeader(codec rangeCodec) []byte {
+	return append([]byte(rangeFileMagic), rangeFileVersion, byte(codec))
+}
+
+// detectRangeCodec consumes the header of a range file, if there is one, and returns the codec of the file along with
+// the size of the header.
+func detectRangeCodec(r *bufio.Reader) (rangeCodec, int, error) {
+	head, err := r.Peek(rangeFileHeaderSize)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return 0, 0, fmt.Errorf("reading header: %w", err)
+	}
+
+	if !bytes.HasPrefix(head, []byte(rangeFileMagic)) {
+		if bytes.HasPrefix(head, zstdMagic) {
+			return codecZstd, 0, nil
+		}
+
+		return codecPlain, 0, nil
+	}
+
+	if len(head) < rangeFileHeaderSize {
+		return 0, 0, errors.New("header is truncated")
+	}
+
+	if version := head[len(rangeFileMagic)]; version != rangeFileVersion {
+		return 0, 0, fmt.Errorf("unsupported format version %d, the file has probably been written by a newer version of this library", version)
+	}
+
+	codec := rangeCodec(head[len(rangeFileMagic)+1])
+	if !codec.valid() {
+		return 0, 0, fmt.Errorf("unknown codec %d, the file has probably been written by a newer version of this library", byte(codec))
+	}
+
+	if _, err := r.Discard(rangeFileHeaderSize); err != nil {
+		return 0, 0, fmt.Errorf("skipping header: %w", err)
+	}
+
+	return codec, rangeFileHeaderSize, nil
+}
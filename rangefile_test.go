@@ -0,0 +1,114 @@
+package hibp
+
+import (
+	"github.com/klauspost/compress/zstd"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestMixedRangeFiles(t *testing.T) {
+	dataDir := t.TempDir()
+
+	compressed := newFSStorage(dataDir, false)
+	plain := newFSStorage(dataDir, true)
+
+	binary, err := newFSStorageFromConfig(dataDir, commonConfig{binaryFormat: true})
+	if err != nil {
+		t.Fatalf("creating storage: %v", err)
+	}
+
+	const data = "0005AD76BD555C1D6D771DE417A4B87E4B4:10\r\n000A8DAE4228F821FB418F59826079BF368:4"
+
+	for key, store := range map[string]*fsStorage{"00000": compressed, "00001": plain, "00002": binary} {
+		if err := store.Save(key, "etag"+key, []byte(data)); err != nil {
+			t.Fatalf("saving range %q: %v", key, err)
+		}
+	}
+
+	// Files written before range files had a header
+	writeLegacyRangeFile(t, compressed, "00003", true)
+	writeLegacyRangeFile(t, compressed, "00004", false)
+
+	// Every storage reads every file, regardless of its own settings
+	for _, store := range []*fsStorage{compressed, plain, binary} {
+		for _, key := range []string{"00000", "00001", "00002", "00003", "00004"} {
+			etag, err := store.LoadETag(key)
+			if err != nil {
+				t.Fatalf("loading etag of range %q: %v", key, err)
+			}
+
+			if etag != "etag"+key {
+				t.Fatalf("unexpected etag of range %q: %q", key, etag)
+			}
+
+			reader, err := store.LoadData(key)
+			if err != nil {
+				t.Fatalf("loading range %q: %v", key, err)
+			}
+
+			actual, err := io.ReadAll(reader)
+			_ = reader.Close()
+
+			if err != nil || string(actual) != data {
+				t.Fatalf("unexpected data of range %q: %q, %v", key, actual, err)
+			}
+
+			count, found, err := store.LookupSuffix(key, "000A8DAE4228F821FB418F59826079BF368")
+			if err != nil || !found || count != 4 {
+				t.Fatalf("unexpected lookup result in range %q: %d, %v, %v", key, count, found, err)
+			}
+		}
+	}
+}
+
+func TestUnsupportedRangeFiles(t *testing.T) {
+	store := newFSStorage(t.TempDir(), false)
+
+	if err := store.Save("00000", "etag", []byte("suffix:1")); err != nil {
+		t.Fatalf("saving range: %v", err)
+	}
+
+	for name, tc := range map[string]struct {
+		header   []byte
+		expected string
+	}{
+		"newer version": {header: []byte(rangeFileMagic + "\x02\x01"), expected: "unsupported format version 2"},
+		"unknown codec": {header: []byte(rangeFileMagic + "\x01\x09"), expected: "unknown codec 9"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if err := os.WriteFile(store.filePath("00000"), append(tc.header, "etag\nsuffix:1"...), 0o644); err != nil {
+				t.Fatalf("writing file: %v", err)
+			}
+
+			if _, err := store.LoadData("00000"); err == nil || !strings.Contains(err.Error(), tc.expected) {
+				t.Fatalf("expected an error containing %q, got %v", tc.expected, err)
+			}
+		})
+	}
+}
+
+func writeLegacyRangeFile(t *testing.T, store *fsStorage, key string, compressed bool) {
+	t.Helper()
+
+	content := []byte("etag" + key + "\n0005AD76BD555C1D6D771DE417A4B87E4B4:10\r\n000A8DAE4228F821FB418F59826079BF368:4")
+
+	if compressed {
+		enc, err := zstd.NewWriter(nil)
+		if err != nil {
+			t.Fatalf("creating zstd writer: %v", err)
+		}
+
+		content = enc.EncodeAll(content, nil)
+		_ = enc.Close()
+	}
+
+	if err := store.createDirs(key); err != nil {
+		t.Fatalf("creating dirs: %v", err)
+	}
+
+	if err := os.WriteFile(store.filePath(key), content, 0o644); err != nil {
+		t.Fatalf("writing file: %v", err)
+	}
+}
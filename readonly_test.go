@@ -0,0 +1,98 @@
+package hibp
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadOnly(t *testing.T) {
+	dataDir := path.Join(t.TempDir(), "never-created")
+
+	h, err := New(WithDataDir(dataDir), WithReadOnly(), WithSnapshots(1), WithSharedLocks())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := h.Sync(); !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("expected ErrReadOnly, got %v", err)
+	}
+
+	if err := h.Import(strings.NewReader("")); !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("expected ErrReadOnly, got %v", err)
+	}
+
+	if _, err := h.Verify(context.Background(), VerifyWithRepair()); !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("expected ErrReadOnly, got %v", err)
+	}
+
+	if _, err := h.Verify(context.Background(), VerifyWithLastRange(0xF)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := os.Stat(dataDir); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected data dir to not be created, got %v", err)
+	}
+}
+
+func TestReadOnlyPicksUpExternalSyncs(t *testing.T) {
+	dataDir := t.TempDir()
+
+	h, err := New(WithDataDir(dataDir), WithReadOnly())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if synced := h.MostRecentSuccessfulSync(); !synced.IsZero() {
+		t.Fatalf("expected no sync, got %v", synced)
+	}
+
+	timestampPath := path.Join(dataDir, hibpMostRecentSuccessfulSyncPath)
+
+	for _, seconds := range []int64{1000, 2000} {
+		if err := os.WriteFile(timestampPath, []byte(strconv.FormatInt(seconds, 10)), 0o644); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		// The modification time might not change otherwise, given its granularity
+		if err := os.Chtimes(timestampPath, time.Unix(seconds, 0), time.Unix(seconds, 0)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		previous := h.MostRecentSuccessfulSync()
+
+		// The file is checked at most once per timestampRefreshInterval, which is simulated to have passed
+		h.datasets[ModeSHA1].timestampCheckedAt.Store(0)
+
+		if synced := h.MostRecentSuccessfulSync(); !synced.Equal(time.Unix(seconds, 0)) {
+			t.Fatalf("expected %d, got %v", seconds, synced)
+		}
+
+		if previous.Equal(time.Unix(seconds, 0)) {
+			t.Fatalf("expected the change to not be picked up within the refresh interval")
+		}
+	}
+
+	store, err := NewFSStorage(dataDir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := store.Save("00000", "etag", []byte("ABC:1")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	recorder := httptest.NewRecorder()
+	h.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/range/00000", nil))
+
+	if lastModified := recorder.Header().Get("Last-Modified"); lastModified != time.Unix(2000, 0).UTC().Format(http.TimeFormat) {
+		t.Fatalf("unexpected Last-Modified: %q", lastModified)
+	}
+}
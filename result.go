@@ -0,0 +1,64 @@
+package hibp
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// SyncResult summarizes a single run of Sync.
+type SyncResult struct {
+	// Mode is the hash family that has been synced.
+	Mode HashMode
+	// Started is the point in time the sync started.
+	Started time.Time
+	// Duration is the time it took to run the sync.
+	Duration time.Duration
+	// Updated is the number of ranges that changed upstream and have been written to the local storage.
+	Updated int64
+	// NotModified is the number of ranges that did not change since the previous sync (according to their ETag).
+	NotModified int64
+	// Failed is the number of ranges that could not be synced.
+	Failed int64
+	// BytesDownloaded is the number of bytes of all range responses received from upstream.
+	BytesDownloaded int64
+	// BytesWritten is the number of bytes the updated ranges occupy in the local storage, i.e., after compression.
+	// It stays 0 for storages that are unable to report the size of a range.
+	BytesWritten int64
+}
+
+// Processed returns the number of ranges that have been processed during the sync, regardless of their outcome.
+func (r SyncResult) Processed() int64 {
+	return r.Updated + r.NotModified + r.Failed
+}
+
+// UpdatedRatio returns the fraction of processed ranges that have been updated, e.g., to alert on unusually large
+// changes of the upstream dataset.
+func (r SyncResult) UpdatedRatio() float64 {
+	if r.Processed() == 0 {
+		return 0
+	}
+
+	return float64(r.Updated) / float64(r.Processed())
+}
+
+// syncStats collects the statistics of a running sync, it is safe for concurrent use.
+type syncStats struct {
+	updated         atomic.Int64
+	notModified     atomic.Int64
+	failed          atomic.Int64
+	bytesDownloaded atomic.Int64
+	bytesWritten    atomic.Int64
+}
+
+func (s *syncStats) result(mode HashMode, started time.Time) SyncResult {
+	return SyncResult{
+		Mode:            mode,
+		Started:         started,
+		Duration:        time.Since(started),
+		Updated:         s.updated.Load(),
+		NotModified:     s.notModified.Load(),
+		Failed:          s.failed.Load(),
+		BytesDownloaded: s.bytesDownloaded.Load(),
+		BytesWritten:    s.bytesWritten.Load(),
+	}
+}
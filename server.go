@@ -0,0 +1,225 @@
+package hibp
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"strings"
+)
+
+const rangePathPrefix = "/range/"
+
+// Handler returns an http.Handler that serves the local dataset the same way the official Pwned Passwords API does.
+// It answers "GET /range/{prefix}" with the stored range, byte-for-byte as it has been received from upstream,
+// supports conditional requests using the stored ETag ("If-None-Match"), response padding ("Add-Padding: true")
+// and the NTLM dataset ("?mode=ntlm").
+// Responses carry the time of the most recent successful sync as "Last-Modified".
+// The ETag always describes the data sent, even if the range is replaced concurrently, e.g., by a sync.
+// Existing clients of the official API can therefore be pointed at it instead.
+func (h *HIBP) Handler() http.Handler {
+	return &rangeHandler{hibp: h}
+}
+
+type rangeHandler struct {
+	hibp *HIBP
+}
+
+func (rh *rangeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+
+		return
+	}
+
+	if !strings.HasPrefix(r.URL.Path, rangePathPrefix) {
+		http.NotFound(w, r)
+
+		return
+	}
+
+	prefix := strings.ToUpper(strings.TrimPrefix(r.URL.Path, rangePathPrefix))
+	if _, err := hex.DecodeString(prefix + "0"); len(prefix) != prefixLength || err != nil {
+		http.Error(w, "The hash prefix was not in a valid format", http.StatusBadRequest)
+
+		return
+	}
+
+	mode := ModeSHA1
+	if modeParam := r.URL.Query().Get("mode"); modeParam != "" {
+		var err error
+
+		mode, err = ParseHashMode(modeParam)
+		if err != nil {
+			http.Error(w, "The mode was not in a valid format", http.StatusBadRequest)
+
+			return
+		}
+	}
+
+	ds, err := rh.hibp.dataset(mode)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	// The ETag has to describe the data sent, even if the range is replaced concurrently, e.g., by a sync
+	etag, reader, err := loadRange(ds.store, prefix)
+	if err != nil {
+		writeStorageError(w, err)
+
+		return
+	}
+	defer reader.Close()
+
+	w.Header().Set("Content-Type", "text/plain")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	// Padded and unpadded responses share the ETag, caches must not serve one for the other
+	w.Header().Set("Vary", "Add-Padding")
+
+	if synced := rh.hibp.MostRecentSuccessfulSyncOf(mode); !synced.IsZero() {
+		w.Header().Set("Last-Modified", synced.UTC().Format(http.TimeFormat))
+	}
+
+	if etag != "" {
+		w.Header().Set("ETag", etag)
+	}
+
+	if matchesIfNoneMatch(r.Header.Values("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+
+		return
+	}
+
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	if !strings.EqualFold(r.Header.Get("Add-Padding"), "true") {
+		// Nothing we can do about errors at this point, the status code has already been sent.
+		_, _ = io.Copy(w, reader)
+
+		return
+	}
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		http.Error(w, "loading range", http.StatusInternalServerError)
+
+		return
+	}
+
+	_, _ = w.Write(padRange(data, mode.hashLength()-prefixLength))
+}
+
+// matchesIfNoneMatch reports whether the given "If-None-Match" header values, each a comma-separated list of entity
+// tags, contain "*" or an entity tag matching the ETag of the range, see RFC 9110, section 13.1.2.
+// As for GET and HEAD requests, entity tags are compared using the weak comparison, i.e., regardless of "W/".
+func matchesIfNoneMatch(values []string, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+
+	for _, value := range values {
+		for value != "" {
+			value = strings.TrimLeft(value, " \t,")
+			if value == "" {
+				break
+			}
+
+			if value[0] == '*' {
+				return true
+			}
+
+			var candidate string
+
+			candidate, value = nextEntityTag(value)
+			if candidate == "" {
+				// Malformed lists do not match anything
+				break
+			}
+
+			if etag != "" && strings.TrimPrefix(candidate, "W/") == etag {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// nextEntityTag splits the first entity tag off the given list, which may contain commas within its quotes.
+// An empty entity tag is returned if the list does not start with a well-formed one.
+func nextEntityTag(list string) (string, string) {
+	start := 0
+	if strings.HasPrefix(list, "W/") {
+		start = 2
+	}
+
+	if len(list) <= start || list[start] != '"' {
+		return "", ""
+	}
+
+	end := strings.IndexByte(list[start+1:], '"')
+	if end < 0 {
+		return "", ""
+	}
+
+	end += start + 2
+
+	return list[:end], list[end:]
+}
+
+// rangeLoader is implemented by storages that are able to load the ETag and the data of a range at once, i.e., from
+// the same version of the range.
+type rangeLoader interface {
+	LoadRange(key string) (string, io.ReadCloser, error)
+}
+
+// maxRangeLoadAttempts limits how often a range is loaded again by loadRange, if it keeps being replaced meanwhile.
+const maxRangeLoadAttempts = 3
+
+// loadRange returns the ETag and the data of a range, both from the same version of it.
+// Storages not able to load them at once are asked for the ETag once more after reading the data; the range is loaded
+// again if it has been replaced in the meantime.
+func loadRange(store Storage, key string) (string, io.ReadCloser, error) {
+	if l, ok := store.(rangeLoader); ok {
+		return l.LoadRange(key)
+	}
+
+	for attempt := 0; attempt < maxRangeLoadAttempts; attempt++ {
+		etag, err := store.LoadETag(key)
+		if err != nil {
+			return "", nil, err
+		}
+
+		data, err := loadRangeData(store, key)
+		if err != nil {
+			return "", nil, err
+		}
+
+		etagAfter, err := store.LoadETag(key)
+		if err != nil {
+			return "", nil, err
+		}
+
+		if etag == etagAfter {
+			return etag, io.NopCloser(bytes.NewReader(data)), nil
+		}
+	}
+
+	return "", nil, fmt.Errorf("range %q kept being replaced while loading it", key)
+}
+
+func writeStorageError(w http.ResponseWriter, err error) {
+	if errors.Is(err, fs.ErrNotExist) {
+		http.Error(w, "range not found", http.StatusNotFound)
+
+		return
+	}
+
+	http.Error(w, "loading range", http.StatusInternalServerError)
+}
@@ -0,0 +1,230 @@
+package hibp
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	h, err := New(WithDataDir(t.TempDir()))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	const sha1Data = "0005AD76BD555C1D6D771DE417A4B87E4B4:10\r\n000A8DAE4228F821FB418F59826079BF368:4"
+
+	if err := h.datasets[ModeSHA1].store.Save("ABCDE", `W/"sha1-etag"`, []byte(sha1Data)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := h.datasets[ModeNTLM].store.Save("ABCDE", `W/"ntlm-etag"`, []byte("000BCDEF0123456789ABCDEF012:7")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	server := httptest.NewServer(h.Handler())
+	defer server.Close()
+
+	request := func(t *testing.T, url string, header http.Header) (*http.Response, []byte) {
+		t.Helper()
+
+		req, err := http.NewRequest(http.MethodGet, server.URL+url, nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		for k, v := range header {
+			req.Header[k] = v
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		defer resp.Body.Close()
+
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		return resp, body
+	}
+
+	t.Run("serves the stored range", func(t *testing.T) {
+		resp, body := request(t, "/range/abcde", nil)
+
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("unexpected status code: %d", resp.StatusCode)
+		}
+
+		if resp.Header.Get("ETag") != `W/"sha1-etag"` {
+			t.Fatalf("unexpected etag: %q", resp.Header.Get("ETag"))
+		}
+
+		if string(body) != sha1Data {
+			t.Fatalf("unexpected body: %q", body)
+		}
+	})
+
+	t.Run("serves the NTLM dataset", func(t *testing.T) {
+		resp, body := request(t, "/range/ABCDE?mode=ntlm", nil)
+
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("unexpected status code: %d", resp.StatusCode)
+		}
+
+		if string(body) != "000BCDEF0123456789ABCDEF012:7" {
+			t.Fatalf("unexpected body: %q", body)
+		}
+	})
+
+	t.Run("answers conditional requests", func(t *testing.T) {
+		resp, body := request(t, "/range/ABCDE", http.Header{"If-None-Match": {`W/"sha1-etag"`}})
+
+		if resp.StatusCode != http.StatusNotModified {
+			t.Fatalf("unexpected status code: %d", resp.StatusCode)
+		}
+
+		if len(body) != 0 {
+			t.Fatalf("unexpected body: %q", body)
+		}
+	})
+
+	t.Run("answers conditional requests listing several entity tags", func(t *testing.T) {
+		for header, expectedStatus := range map[string]int{
+			`"other", W/"sha1-etag"`:  http.StatusNotModified,
+			`"sha1-etag"`:             http.StatusNotModified,
+			`"a,b",W/"sha1-etag"`:     http.StatusNotModified,
+			`*`:                       http.StatusNotModified,
+			`"other", W/"other-etag"`: http.StatusOK,
+			`"sha1-etag`:              http.StatusOK,
+			`W/"other,W/"sha1-etag"`:  http.StatusOK,
+			`"other", sha1-etag, "a"`: http.StatusOK,
+		} {
+			resp, _ := request(t, "/range/ABCDE", http.Header{"If-None-Match": {header}})
+
+			if resp.StatusCode != expectedStatus {
+				t.Fatalf("unexpected status code for %q: %d", header, resp.StatusCode)
+			}
+		}
+
+		resp, _ := request(t, "/range/ABCDE", http.Header{"If-None-Match": {`"other"`, `W/"sha1-etag"`}})
+
+		if resp.StatusCode != http.StatusNotModified {
+			t.Fatalf("unexpected status code for several headers: %d", resp.StatusCode)
+		}
+	})
+
+	t.Run("pads the response", func(t *testing.T) {
+		resp, body := request(t, "/range/ABCDE", http.Header{"Add-Padding": {"true"}})
+
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("unexpected status code: %d", resp.StatusCode)
+		}
+
+		if resp.Header.Get("Vary") != "Add-Padding" {
+			t.Fatalf("unexpected Vary header: %q", resp.Header.Get("Vary"))
+		}
+
+		lines := strings.Split(string(body), "\r\n")
+
+		if len(lines) < minPaddedEntries || len(lines) > maxPaddedEntries {
+			t.Fatalf("unexpected number of lines: %d", len(lines))
+		}
+
+		if !sort.StringsAreSorted(lines) {
+			t.Fatalf("lines are not sorted")
+		}
+
+		for _, line := range strings.Split(sha1Data, "\r\n") {
+			if !bytes.Contains(body, []byte(line)) {
+				t.Fatalf("original line %q is missing", line)
+			}
+		}
+
+		for _, line := range lines {
+			if len(line) < 36 || line[35] != ':' {
+				t.Fatalf("malformed line: %q", line)
+			}
+		}
+	})
+
+	t.Run("rejects invalid requests", func(t *testing.T) {
+		for url, expectedStatus := range map[string]int{
+			"/range/ABCD":           http.StatusBadRequest,
+			"/range/ABCDEF":         http.StatusBadRequest,
+			"/range/XBCDE":          http.StatusBadRequest,
+			"/range/ABCDE?mode=md5": http.StatusBadRequest,
+			"/range/00000":          http.StatusNotFound,
+			"/other/ABCDE":          http.StatusNotFound,
+		} {
+			resp, _ := request(t, url, nil)
+
+			if resp.StatusCode != expectedStatus {
+				t.Fatalf("unexpected status code for %q: %d", url, resp.StatusCode)
+			}
+		}
+	})
+}
+
+// replacingStorage replaces a range right before its data is loaded for the first time, like a sync running
+// concurrently would.
+type replacingStorage struct {
+	Storage
+	replaced bool
+}
+
+func (s *replacingStorage) LoadData(key string) (io.ReadCloser, error) {
+	if !s.replaced {
+		s.replaced = true
+
+		if err := s.Save(key, "new-etag", []byte("000BCDEF0123456789ABCDEF0123456:2")); err != nil {
+			return nil, err
+		}
+	}
+
+	return s.Storage.LoadData(key)
+}
+
+func TestHandlerConsistentETag(t *testing.T) {
+	for name, test := range map[string]struct {
+		store        Storage
+		expectedETag string
+		expectedBody string
+	}{
+		// The file-based storage reads both from the same file
+		"file-based": {expectedETag: "old-etag", expectedBody: "000BCDEF0123456789ABCDEF0123456:1"},
+		// Other storages are asked for the ETag again after reading the data
+		"fallback": {store: &replacingStorage{Storage: NewMemoryStorage()}, expectedETag: "new-etag", expectedBody: "000BCDEF0123456789ABCDEF0123456:2"},
+	} {
+		test := test
+
+		t.Run(name, func(t *testing.T) {
+			options := []CommonOption{WithDataDir(t.TempDir())}
+			if test.store != nil {
+				options = append(options, WithStorage(test.store))
+			}
+
+			h, err := New(options...)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if err := h.datasets[ModeSHA1].store.Save("ABCDE", "old-etag", []byte("000BCDEF0123456789ABCDEF0123456:1")); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			recorder := httptest.NewRecorder()
+			h.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/range/ABCDE", nil))
+
+			if etag, body := recorder.Header().Get("ETag"), recorder.Body.String(); etag != test.expectedETag || body != test.expectedBody {
+				t.Fatalf("unexpected ETag %q and body %q", etag, body)
+			}
+		})
+	}
+}
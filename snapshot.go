@@ -0,0 +1,376 @@
+package hibp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	syncPkg "sync"
+)
+
+// In snapshot mode, the file-based storage keeps every version of the dataset in a generation directory of its own:
+//
+//	.generations/00000001/XX/YYY, .generations/00000002/XX/YYY, ...
+//	.generations/pending  the generation being synced
+//	.current              symlink to the generation readers are served from
+//
+// A sync starts by hard-linking all files of the current generation into the pending one, which is cheap and shares
+// the unchanged ranges between generations; replacing a range writes a new file, so other generations are not
+// affected.
+// Once the sync completes, the pending generation is given the next number and the symlink is replaced atomically,
+// flipping all readers over at once, including those of other processes.
+const (
+	snapshotGenerationsDir  = ".generations"
+	snapshotCurrentLink     = ".current"
+	snapshotPendingName     = "pending"
+	snapshotGenerationWidth = 8
+)
+
+// ErrNoPreviousGeneration is returned by HIBP.Rollback if there is no generation to roll back to.
+var ErrNoPreviousGeneration = errors.New("no previous generation to roll back to")
+
+// snapshotStorage is the file-based storage in snapshot mode, see WithSnapshots.
+// All methods of the Storage interface operate on the current generation; syncs write into a pending generation
+// created by beginGeneration.
+type snapshotStorage struct {
+	*fsStorage
+	dataDir    string
+	keep       int
+	config     commonConfig
+	initLock   syncPkg.Mutex
+	hasCurrent bool
+}
+
+// newSnapshotStorage creates the file-based storage in snapshot mode.
+// Ranges of a dataset created without snapshots are moved into the first generation.
+func newSnapshotStorage(dataDir string, config commonConfig) (*snapshotStorage, error) {
+	s := &snapshotStorage{
+		dataDir: dataDir,
+		keep:    config.keepGenerations,
+		config:  config,
+	}
+
+	if !config.readOnly {
+		if err := s.adoptLegacyRanges(); err != nil {
+			return nil, fmt.Errorf("moving existing ranges into the first generation: %w", err)
+		}
+	}
+
+	current, err := newFSStorageFromConfig(path.Join(dataDir, snapshotCurrentLink), config)
+	if err != nil {
+		return nil, err
+	}
+
+	s.fsStorage = current
+
+	return s, nil
+}
+
+// Save stores a range in the current generation directly, e.g., when importing a dataset.
+// It must not run concurrently with a sync, whose generation is based on the current one.
+func (s *snapshotStorage) Save(key, etag string, data []byte) error {
+	if err := s.ensureCurrentGeneration(); err != nil {
+		return err
+	}
+
+	return s.fsStorage.Save(key, etag, data)
+}
+
+// ensureCurrentGeneration creates an empty first generation if there is none yet, as writing through the symlink
+// requires it to exist.
+func (s *snapshotStorage) ensureCurrentGeneration() error {
+	s.initLock.Lock()
+	defer s.initLock.Unlock()
+
+	if s.hasCurrent {
+		return nil
+	}
+
+	current, err := s.currentGeneration()
+	if err != nil {
+		return err
+	}
+
+	if current == 0 {
+		if err := os.MkdirAll(s.generationDir(1), dirMode); err != nil {
+			return fmt.Errorf("creating generation: %w", err)
+		}
+
+		if err := s.setCurrentGeneration(1); err != nil {
+			return err
+		}
+	}
+
+	s.hasCurrent = true
+
+	return nil
+}
+
+// adoptLegacyRanges moves the ranges of a dataset that has been created without snapshots into the first generation.
+// It is a no-op once the current generation has been set; if interrupted, it continues from where it left off.
+// The data dir is locked meanwhile, as a writer not using snapshots might be in the middle of saving ranges.
+func (s *snapshotStorage) adoptLegacyRanges() (err error) {
+	subDirs, err := s.legacyRangeDirs()
+	if err != nil || len(subDirs) == 0 {
+		return err
+	}
+
+	unlock, err := lockDataDir(s.dataDir, true, false)
+	if err != nil {
+		return err
+	}
+	defer releaseLock(unlock, &err)
+
+	// Somebody else might have adopted the ranges while we were not holding the lock
+	if subDirs, err = s.legacyRangeDirs(); err != nil || len(subDirs) == 0 {
+		return err
+	}
+
+	generationDir := s.generationDir(1)
+
+	if err := os.MkdirAll(generationDir, dirMode); err != nil {
+		return err
+	}
+
+	for _, name := range append(subDirs, hibpDictionaryPath) {
+		if err := os.Rename(path.Join(s.dataDir, name), path.Join(generationDir, name)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+
+	return s.setCurrentGeneration(1)
+}
+
+// legacyRangeDirs returns the range directories of a dataset that has been created without snapshots, there are none
+// once the current generation has been set.
+func (s *snapshotStorage) legacyRangeDirs() ([]string, error) {
+	if _, err := os.Lstat(path.Join(s.dataDir, snapshotCurrentLink)); err == nil || !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	return newFSStorage(s.dataDir, true).rangeDirs()
+}
+
+// beginGeneration returns the storage of the pending generation, which a sync writes into.
+// A pending generation left behind by a failed or interrupted sync is continued.
+func (s *snapshotStorage) beginGeneration() (*fsStorage, error) {
+	pendingDir := path.Join(s.dataDir, snapshotGenerationsDir, snapshotPendingName)
+
+	if _, err := os.Stat(pendingDir); errors.Is(err, fs.ErrNotExist) {
+		// Linking into a temporary directory first ensures a pending generation is always complete
+		linkingDir := pendingDir + tmpSuffix
+
+		if err := os.RemoveAll(linkingDir); err != nil {
+			return nil, fmt.Errorf("removing incomplete generation: %w", err)
+		}
+
+		if err := os.MkdirAll(linkingDir, dirMode); err != nil {
+			return nil, fmt.Errorf("creating generation: %w", err)
+		}
+
+		current, err := s.currentGeneration()
+		if err != nil {
+			return nil, err
+		}
+
+		if current > 0 {
+			if err := linkTree(s.generationDir(current), linkingDir); err != nil {
+				return nil, fmt.Errorf("linking generation %d: %w", current, err)
+			}
+		}
+
+		if err := os.Rename(linkingDir, pendingDir); err != nil {
+			return nil, fmt.Errorf("creating generation: %w", err)
+		}
+	} else if err != nil {
+		return nil, fmt.Errorf("accessing pending generation: %w", err)
+	}
+
+	return newFSStorageFromConfig(pendingDir, s.config)
+}
+
+// commitGeneration makes the pending generation the current one and removes the generations exceeding the number of
+// generations to keep.
+func (s *snapshotStorage) commitGeneration() error {
+	generations, err := s.generations()
+	if err != nil {
+		return err
+	}
+
+	next := 1
+	if len(generations) > 0 {
+		next = generations[len(generations)-1] + 1
+	}
+
+	pendingDir := path.Join(s.dataDir, snapshotGenerationsDir, snapshotPendingName)
+
+	if err := os.Rename(pendingDir, s.generationDir(next)); err != nil {
+		return fmt.Errorf("finalizing generation %d: %w", next, err)
+	}
+
+	if err := s.setCurrentGeneration(next); err != nil {
+		return err
+	}
+
+	for _, generation := range generations[:max(0, len(generations)-s.keep)] {
+		if err := os.RemoveAll(s.generationDir(generation)); err != nil {
+			return fmt.Errorf("removing generation %d: %w", generation, err)
+		}
+	}
+
+	return nil
+}
+
+// rollback makes the generation preceding the current one the current generation.
+// The current generation and the pending one, if any, are removed, as they are based on the discarded data.
+func (s *snapshotStorage) rollback() error {
+	current, err := s.currentGeneration()
+	if err != nil {
+		return err
+	}
+
+	generations, err := s.generations()
+	if err != nil {
+		return err
+	}
+
+	i, _ := slices.BinarySearch(generations, current)
+	if i == 0 {
+		return ErrNoPreviousGeneration
+	}
+
+	previous := generations[i-1]
+
+	if err := s.setCurrentGeneration(previous); err != nil {
+		return err
+	}
+
+	for _, generation := range generations[i:] {
+		if err := os.RemoveAll(s.generationDir(generation)); err != nil {
+			return fmt.Errorf("removing generation %d: %w", generation, err)
+		}
+	}
+
+	if err := os.RemoveAll(path.Join(s.dataDir, snapshotGenerationsDir, snapshotPendingName)); err != nil {
+		return fmt.Errorf("removing pending generation: %w", err)
+	}
+
+	return nil
+}
+
+// setCurrentGeneration replaces the symlink pointing to the current generation atomically.
+func (s *snapshotStorage) setCurrentGeneration(generation int) error {
+	linkPath := path.Join(s.dataDir, snapshotCurrentLink)
+	linkPathTmp := linkPath + tmpSuffix
+
+	if err := os.Remove(linkPathTmp); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("removing %q: %w", linkPathTmp, err)
+	}
+
+	// The target is relative, so the data dir can be moved around
+	if err := os.Symlink(path.Join(snapshotGenerationsDir, formatGeneration(generation)), linkPathTmp); err != nil {
+		return fmt.Errorf("creating symlink to generation %d: %w", generation, err)
+	}
+
+	if err := os.Rename(linkPathTmp, linkPath); err != nil {
+		return fmt.Errorf("switching to generation %d: %w", generation, err)
+	}
+
+	return nil
+}
+
+// currentGeneration returns the number of the current generation, or 0 if there is none yet.
+func (s *snapshotStorage) currentGeneration() (int, error) {
+	target, err := os.Readlink(path.Join(s.dataDir, snapshotCurrentLink))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return 0, nil
+		}
+
+		return 0, fmt.Errorf("reading current generation: %w", err)
+	}
+
+	generation, err := strconv.Atoi(path.Base(target))
+	if err != nil {
+		return 0, fmt.Errorf("parsing current generation %q: %w", target, err)
+	}
+
+	return generation, nil
+}
+
+// generations returns the numbers of all complete generations in ascending order.
+func (s *snapshotStorage) generations() ([]int, error) {
+	entries, err := os.ReadDir(path.Join(s.dataDir, snapshotGenerationsDir))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("listing generations: %w", err)
+	}
+
+	var generations []int
+
+	for _, entry := range entries {
+		if generation, err := strconv.Atoi(entry.Name()); entry.IsDir() && err == nil {
+			generations = append(generations, generation)
+		}
+	}
+
+	slices.Sort(generations)
+
+	return generations, nil
+}
+
+func (s *snapshotStorage) generationDir(generation int) string {
+	return path.Join(s.dataDir, snapshotGenerationsDir, formatGeneration(generation))
+}
+
+func formatGeneration(generation int) string {
+	return fmt.Sprintf("%0*d", snapshotGenerationWidth, generation)
+}
+
+// linkTree recreates the directory tree below src in dst, hard-linking all files but leftovers of interrupted writes.
+// Files are copied if they cannot be linked, e.g., because the file system does not support hard links.
+func linkTree(src, dst string) error {
+	return filepath.WalkDir(src, func(srcPath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, srcPath)
+		if err != nil {
+			return err
+		}
+
+		dstPath := filepath.Join(dst, rel)
+
+		switch {
+		case entry.IsDir():
+			return os.MkdirAll(dstPath, dirMode)
+		case strings.HasSuffix(entry.Name(), tmpSuffix):
+			return nil
+		}
+
+		if err := os.Link(srcPath, dstPath); err == nil {
+			return nil
+		}
+
+		return copyFile(srcPath, dstPath)
+	})
+}
+
+func copyFile(srcPath, dstPath string) error {
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	return writeFileSynced(dstPath, func(w io.Writer) error {
+		_, err := io.Copy(w, src)
+		return err
+	})
+}
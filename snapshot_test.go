@@ -0,0 +1,186 @@
+package hibp
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestSnapshots(t *testing.T) {
+	var (
+		version atomic.Int64
+		failing atomic.Bool
+	)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing.Load() && strings.HasSuffix(r.URL.Path, "F") {
+			w.WriteHeader(http.StatusNotFound)
+
+			return
+		}
+
+		data := "ABC:1"
+
+		// Only range 00001 changes between versions
+		if strings.HasSuffix(r.URL.Path, "00001") {
+			data = "ABC:" + string(rune('0'+version.Load()))
+		}
+
+		if r.Header.Get("If-None-Match") == data {
+			w.WriteHeader(http.StatusNotModified)
+
+			return
+		}
+
+		w.Header().Set("ETag", data)
+		_, _ = w.Write([]byte(data))
+	}))
+	defer server.Close()
+
+	dataDir := t.TempDir()
+
+	h, err := New(WithDataDir(dataDir), WithSnapshots(1))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	syncOptions := []SyncOption{
+		SyncWithEndpoint(server.URL + "/range/"),
+		SyncWithLastRange(0xF),
+		SyncWithoutTrackingFailedRangesInFile(),
+	}
+
+	query := func() string {
+		t.Helper()
+
+		reader, err := h.Query("00001")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		defer reader.Close()
+
+		data, err := io.ReadAll(reader)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		return string(data)
+	}
+
+	for v := int64(1); v <= 3; v++ {
+		version.Store(v)
+
+		if err := h.Sync(syncOptions...); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	if data := query(); data != "ABC:3" {
+		t.Fatalf("unexpected data: %q", data)
+	}
+
+	// Unchanged ranges are shared between generations
+	current, err := os.Stat(path.Join(dataDir, snapshotGenerationsDir, formatGeneration(3), "00", "000"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	previous, err := os.Stat(path.Join(dataDir, snapshotGenerationsDir, formatGeneration(2), "00", "000"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !os.SameFile(current, previous) {
+		t.Fatal("expected unchanged range to be hard-linked")
+	}
+
+	// Only one previous generation is kept
+	if _, err := os.Stat(path.Join(dataDir, snapshotGenerationsDir, formatGeneration(1))); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected generation 1 to be removed, got %v", err)
+	}
+
+	// A failing sync must not become visible
+	version.Store(4)
+	failing.Store(true)
+
+	var syncErr *SyncError
+	if err := h.Sync(syncOptions...); !errors.As(err, &syncErr) {
+		t.Fatalf("expected sync error, got %v", err)
+	}
+
+	if data := query(); data != "ABC:3" {
+		t.Fatalf("expected the current generation to be served, got %q", data)
+	}
+
+	if err := h.Rollback(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if data := query(); data != "ABC:2" {
+		t.Fatalf("expected the previous generation to be served, got %q", data)
+	}
+
+	if _, err := os.Stat(path.Join(dataDir, snapshotGenerationsDir, snapshotPendingName)); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected the pending generation to be removed, got %v", err)
+	}
+
+	if err := h.Rollback(); !errors.Is(err, ErrNoPreviousGeneration) {
+		t.Fatalf("expected ErrNoPreviousGeneration, got %v", err)
+	}
+}
+
+func TestSnapshotsAdoptExistingDataset(t *testing.T) {
+	dataDir := t.TempDir()
+
+	store, err := NewFSStorage(dataDir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := store.Save("00001", "etag", []byte("ABC:1")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The ranges are not moved while another process might be writing them
+	unlock, err := LockDataDir(dataDir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := New(WithDataDir(dataDir), WithSnapshots(1)); !errors.Is(err, ErrDataDirLocked) {
+		t.Fatalf("expected ErrDataDirLocked, got %v", err)
+	}
+
+	if err := unlock(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	h, err := New(WithDataDir(dataDir), WithSnapshots(1))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := os.Stat(path.Join(dataDir, snapshotGenerationsDir, formatGeneration(1), "00", "001")); err != nil {
+		t.Fatalf("expected range to be moved into the first generation: %v", err)
+	}
+
+	reader, err := h.Query("00001")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer reader.Close()
+
+	if data, err := io.ReadAll(reader); err != nil || string(data) != "ABC:1" {
+		t.Fatalf("unexpected data: %q, %v", data, err)
+	}
+
+	if err := h.Rollback(); !errors.Is(err, ErrNoPreviousGeneration) {
+		t.Fatalf("expected ErrNoPreviousGeneration, got %v", err)
+	}
+}
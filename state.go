@@ -0,0 +1,321 @@
+package hibp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+	"os"
+	"slices"
+	"strconv"
+	syncPkg "sync"
+)
+
+// The state file starts with a fixed header, followed by a bitmap with one bit per range, marking the ranges that
+// have been synced successfully, and the list of ranges that failed permanently:
+//
+//	magic (8 bytes) | version (1 byte) | bitmap (2^20 bits) | number of failed ranges (uint32) | failed ranges (uint32 each)
+//
+// All integers are encoded in big endian byte order.
+// Anything following the list of failed ranges is ignored, which allows rewriting the state in place even if
+// the underlying io.ReadWriteSeeker cannot be truncated.
+const (
+	stateMagic      = "HIBPSYNC"
+	stateVersion    = 1
+	numRanges       = defaultLastRange + 1
+	stateBitmapSize = numRanges / 8
+	stateHeaderSize = len(stateMagic) + 1
+)
+
+// syncState keeps track of the ranges that have been synced successfully and of those that failed permanently.
+// It is safe for concurrent use.
+type syncState struct {
+	lock   syncPkg.Mutex
+	done   []byte
+	failed map[int64]struct{}
+}
+
+func newSyncState() *syncState {
+	return &syncState{
+		done:   make([]byte, stateBitmapSize),
+		failed: make(map[int64]struct{}),
+	}
+}
+
+func (s *syncState) isDone(r int64) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.done[r/8]&(1<<(r%8)) != 0
+}
+
+func (s *syncState) markDone(r int64) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.done[r/8] |= 1 << (r % 8)
+	delete(s.failed, r)
+}
+
+// markPending reverts marking a range as done, so it gets processed again.
+func (s *syncState) markPending(r int64) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.done[r/8] &^= 1 << (r % 8)
+}
+
+func (s *syncState) markFailed(r int64) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.failed[r] = struct{}{}
+}
+
+// countDone returns the number of ranges in [from, to) that have been synced successfully.
+func (s *syncState) countDone(from, to int64) int64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var count int64
+
+	for r := from; r < to; r++ {
+		if r%8 == 0 && r+8 <= to {
+			count += int64(bits.OnesCount8(s.done[r/8]))
+			r += 7
+
+			continue
+		}
+
+		if s.done[r/8]&(1<<(r%8)) != 0 {
+			count++
+		}
+	}
+
+	return count
+}
+
+// covers reports whether every range in [from, to) has either been synced successfully or failed permanently,
+// i.e., whether a sync got through all of them instead of being interrupted.
+func (s *syncState) covers(from, to int64) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for r := from; r < to; r++ {
+		if r%8 == 0 && r+8 <= to && s.done[r/8] == 0xFF {
+			r += 7
+
+			continue
+		}
+
+		if s.done[r/8]&(1<<(r%8)) != 0 {
+			continue
+		}
+
+		if _, failed := s.failed[r]; !failed {
+			return false
+		}
+	}
+
+	return true
+}
+
+// failedRanges returns the ranges that failed permanently in ascending order.
+func (s *syncState) failedRanges() []int64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	failed := make([]int64, 0, len(s.failed))
+	for r := range s.failed {
+		failed = append(failed, r)
+	}
+
+	slices.Sort(failed)
+
+	return failed
+}
+
+func (s *syncState) encode() []byte {
+	failed := s.failedRanges()
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	buf := make([]byte, 0, stateHeaderSize+stateBitmapSize+4+4*len(failed))
+	buf = append(buf, stateMagic...)
+	buf = append(buf, stateVersion)
+	buf = append(buf, s.done...)
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(failed)))
+
+	for _, r := range failed {
+		buf = binary.BigEndian.AppendUint32(buf, uint32(r))
+	}
+
+	return buf
+}
+
+// decodeSyncState parses the given state.
+// Besides the current format, it understands the legacy format which consisted of the lowest range that was still
+// in flight; all ranges below it are considered to be done.
+func decodeSyncState(data []byte) (*syncState, error) {
+	state := newSyncState()
+
+	if !bytes.HasPrefix(data, []byte(stateMagic)) {
+		data = bytes.TrimSpace(data)
+
+		if len(data) == 0 {
+			return state, nil
+		}
+
+		lowest, err := strconv.ParseInt(string(data), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("parsing legacy state: %w", err)
+		}
+
+		for r := int64(0); r < min(lowest, numRanges); r++ {
+			state.markDone(r)
+		}
+
+		return state, nil
+	}
+
+	if len(data) < stateHeaderSize+stateBitmapSize+4 {
+		return nil, errors.New("state is truncated")
+	}
+
+	if version := data[len(stateMagic)]; version != stateVersion {
+		return nil, fmt.Errorf("unsupported state version %d", version)
+	}
+
+	data = data[stateHeaderSize:]
+	copy(state.done, data[:stateBitmapSize])
+	data = data[stateBitmapSize:]
+
+	numFailed := int(binary.BigEndian.Uint32(data))
+	data = data[4:]
+
+	if len(data) < 4*numFailed {
+		return nil, errors.New("list of failed ranges is truncated")
+	}
+
+	for i := 0; i < numFailed; i++ {
+		r := int64(binary.BigEndian.Uint32(data[4*i:]))
+		if r >= numRanges {
+			return nil, fmt.Errorf("invalid failed range %d", r)
+		}
+
+		state.failed[r] = struct{}{}
+	}
+
+	return state, nil
+}
+
+func readStateFile(stateFile io.ReadWriteSeeker) (*syncState, error) {
+	data, err := io.ReadAll(stateFile)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return newSyncState(), nil
+		}
+
+		return nil, fmt.Errorf("reading state file: %w", err)
+	}
+
+	state, err := decodeSyncState(data)
+	if err != nil {
+		return nil, fmt.Errorf("parsing state file: %w", err)
+	}
+
+	if _, err := stateFile.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("seeking to beginning of state file: %w", err)
+	}
+
+	return state, nil
+}
+
+func writeStateFile(stateFile io.ReadWriteSeeker, state *syncState) error {
+	data := state.encode()
+
+	if _, err := stateFile.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("seeking to beginning of state file: %w", err)
+	}
+
+	if _, err := stateFile.Write(data); err != nil {
+		return fmt.Errorf("writing state file: %w", err)
+	}
+
+	// Not strictly necessary, as the format allows trailing garbage, but it keeps the file tidy.
+	if truncater, ok := stateFile.(interface{ Truncate(size int64) error }); ok {
+		if err := truncater.Truncate(int64(len(data))); err != nil {
+			return fmt.Errorf("truncating state file: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// wrapWithStateUpdate persists the state roughly every 1000 processed ranges and once the sync is complete.
+func wrapWithStateUpdate(state *syncState, stateFile io.ReadWriteSeeker, innerProgressFn ProgressFunc) ProgressFunc {
+	lastPersisted := int64(-1)
+
+	return func(lowest, current, to, processed, remaining int64) error {
+		if lastPersisted < 0 || processed >= lastPersisted+1000 || remaining == 0 {
+			if err := writeStateFile(stateFile, state); err != nil {
+				fmt.Printf("updating state file: %v\n", err)
+			} else {
+				lastPersisted = processed
+			}
+		}
+
+		return innerProgressFn(lowest, current, to, processed, remaining)
+	}
+}
+
+func readFailedRangesFile(filePath string) ([]int64, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+
+		return nil, fmt.Errorf("reading failed ranges from %q: %w", filePath, err)
+	}
+
+	var failed []int64
+
+	for _, line := range bytes.Fields(data) {
+		r, err := parseRangeString(string(line))
+		if err != nil {
+			return nil, fmt.Errorf("parsing failed ranges from %q: %w", filePath, err)
+		}
+
+		failed = append(failed, r)
+	}
+
+	return failed, nil
+}
+
+// writeFailedRangesFile writes the given ranges to the file, one prefix per line.
+// The file gets removed if there are no failed ranges.
+func writeFailedRangesFile(filePath string, failed []int64) error {
+	if len(failed) == 0 {
+		if err := os.Remove(filePath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("removing failed ranges file %q: %w", filePath, err)
+		}
+
+		return nil
+	}
+
+	var buf bytes.Buffer
+
+	for _, r := range failed {
+		buf.WriteString(toRangeString(r) + "\n")
+	}
+
+	if err := os.WriteFile(filePath, buf.Bytes(), 0o644); err != nil {
+		return fmt.Errorf("writing failed ranges to %q: %w", filePath, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,184 @@
+package hibp
+
+import (
+	"context"
+	"errors"
+	"github.com/alitto/pond"
+	"github.com/h2non/gock"
+	"go.uber.org/mock/gomock"
+	"net/http"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+func TestSyncStateEncoding(t *testing.T) {
+	state := newSyncState()
+	state.markDone(0)
+	state.markDone(7)
+	state.markDone(defaultLastRange)
+	state.markFailed(3)
+	state.markFailed(0xABCDE)
+
+	decoded, err := decodeSyncState(state.encode())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, r := range []int64{0, 7, defaultLastRange} {
+		if !decoded.isDone(r) {
+			t.Fatalf("expected range %d to be done", r)
+		}
+	}
+
+	if decoded.countDone(0, numRanges) != 3 {
+		t.Fatalf("unexpected number of done ranges: %d", decoded.countDone(0, numRanges))
+	}
+
+	if !reflect.DeepEqual(decoded.failedRanges(), []int64{3, 0xABCDE}) {
+		t.Fatalf("unexpected failed ranges: %v", decoded.failedRanges())
+	}
+
+	// Trailing data, e.g., left over from a previous state with more failed ranges, is ignored
+	if _, err := decodeSyncState(append(state.encode(), 0xFF, 0xFF)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := decodeSyncState(state.encode()[:100]); err == nil {
+		t.Fatalf("expected an error for a truncated state")
+	}
+}
+
+func TestSyncStateLegacyMigration(t *testing.T) {
+	stateFile, err := os.Create(path.Join(t.TempDir(), "state"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer stateFile.Close()
+
+	if _, err := stateFile.WriteString("1234\n"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := stateFile.Seek(0, 0); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	state, err := readStateFile(stateFile)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if state.countDone(0, numRanges) != 1234 || !state.isDone(1233) || state.isDone(1234) {
+		t.Fatalf("legacy state has not been migrated correctly")
+	}
+
+	if err := writeStateFile(stateFile, state); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := stateFile.Seek(0, 0); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	state, err = readStateFile(stateFile)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if state.countDone(0, numRanges) != 1234 {
+		t.Fatalf("unexpected number of done ranges after rewrite: %d", state.countDone(0, numRanges))
+	}
+}
+
+func TestSyncOnlyProcessesGaps(t *testing.T) {
+	httpClient := &http.Client{}
+	gock.InterceptClient(httpClient)
+	defer gock.Off()
+
+	gock.New(baseURL).
+		Get("/range/00001").
+		Reply(200).
+		AddHeader("ETag", "etag").
+		BodyString("suffix1:1")
+	gock.New(baseURL).
+		Get("/range/00003").
+		Times(4).
+		Reply(http.StatusInternalServerError)
+
+	client := &hibpClient{
+		endpoint:   defaultEndpoint,
+		httpClient: httpClient,
+		maxRetries: 3,
+	}
+
+	ctrl := gomock.NewController(t)
+	storageMock := NewMockStorage(ctrl)
+
+	storageMock.EXPECT().LoadETag("00001").Return("", nil)
+	storageMock.EXPECT().Save("00001", "etag", []byte("suffix1:1")).Return(nil)
+	storageMock.EXPECT().LoadETag("00003").Return("", nil)
+
+	state := newSyncState()
+	state.markDone(0)
+	state.markDone(2)
+
+	progressFn := func(_, _, _, _, _ int64) error { return nil }
+
+	if err := sync(context.Background(), 0, 4, client, storageMock, pond.New(1, 0), state, &syncStats{}, progressFn); err == nil {
+		t.Fatalf("expected an error for the failing range")
+	}
+
+	if state.countDone(0, 4) != 3 || state.isDone(3) {
+		t.Fatalf("unexpected state of done ranges")
+	}
+
+	if !reflect.DeepEqual(state.failedRanges(), []int64{3}) {
+		t.Fatalf("unexpected failed ranges: %v", state.failedRanges())
+	}
+
+	if !ctrl.Satisfied() {
+		t.Fatalf("there are unsatisfied expectations")
+	}
+}
+
+func TestSyncProgressErrorLeavesRangePending(t *testing.T) {
+	httpClient := &http.Client{}
+	gock.InterceptClient(httpClient)
+	defer gock.Off()
+
+	gock.New(baseURL).
+		Get("/range/00000").
+		Reply(200).
+		AddHeader("ETag", "etag").
+		BodyString("suffix0:1")
+
+	client := &hibpClient{
+		endpoint:   defaultEndpoint,
+		httpClient: httpClient,
+	}
+
+	ctrl := gomock.NewController(t)
+	storageMock := NewMockStorage(ctrl)
+
+	storageMock.EXPECT().LoadETag("00000").Return("", nil)
+	storageMock.EXPECT().Save("00000", "etag", []byte("suffix0:1")).Return(nil)
+
+	state := newSyncState()
+
+	progressFn := func(_, _, _, _, _ int64) error { return errors.New("aborted") }
+
+	if err := sync(context.Background(), 0, 1, client, storageMock, pond.New(1, 0), state, &syncStats{}, progressFn); err == nil {
+		t.Fatalf("expected an error for the failing progress report")
+	}
+
+	// The range is processed again by a resumed sync as well as by retrying the failed ranges
+	if state.isDone(0) {
+		t.Fatalf("expected the range to not be marked as done")
+	}
+
+	if !reflect.DeepEqual(state.failedRanges(), []int64{0}) {
+		t.Fatalf("unexpected failed ranges: %v", state.failedRanges())
+	}
+}
@@ -2,6 +2,8 @@ package hibp
 
 import (
 	"bufio"
+	"bytes"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"github.com/klauspost/compress/zstd"
@@ -10,34 +12,256 @@ import (
 	"path"
 	"strings"
 	syncPkg "sync"
+	"sync/atomic"
 )
 
 const (
 	dirMode = 0o755 // TODO ???
 )
 
-type storage interface {
+// Storage persists the ranges of a dataset together with their ETags.
+// Keys are the upper-case, 5 characters long prefixes of the ranges.
+// Data follows the format of the upstream API, i.e., lines following the schema "<suffix>:<count>" separated by CRLF.
+// Implementations have to be safe for concurrent use; the conformance of an implementation can be checked using the
+// storagetest package.
+// Implementations may additionally provide a method "Size(key string) (int64, error)" reporting the number of bytes a
+// range occupies, it is used to populate SyncResult.BytesWritten.
+// Implementations able to look up a single hash more efficiently than by reading the whole range may provide a method
+// "LookupSuffix(key, suffix string) (int64, bool, error)", it is used by HIBP.Lookup.
+// Implementations able to load the ETag and the data of a range at once may provide a method
+// "LoadRange(key string) (string, io.ReadCloser, error)", it is used by HIBP.Handler.
+// Implementations supporting SyncAtomically provide the methods "BeginSync() error", "CommitSync() error" and
+// "AbortSync() error".
+type Storage interface {
+	// Save stores the data of a range together with its ETag, replacing any previous version atomically:
+	// concurrent readers observe either the previous or the new version, never a mix of both.
+	// The data must not be retained after the call returns, the caller is free to modify it.
 	Save(key, etag string, data []byte) error
+	// LoadETag returns the ETag of a range.
+	// If the range does not exist, an error wrapping fs.ErrNotExist is returned.
 	LoadETag(key string) (string, error)
+	// LoadData returns a reader for the data of a range, the caller has to close it.
+	// Saving the range while the reader is in use must not affect what is read.
+	// If the range does not exist, an error wrapping fs.ErrNotExist is returned.
 	LoadData(key string) (io.ReadCloser, error)
 }
 
+// sizer is implemented by storages that are able to report how many bytes a range occupies.
+type sizer interface {
+	Size(key string) (int64, error)
+}
+
+// syncTransactor is implemented by storages that are able to apply all changes of a sync at once, see SyncAtomically.
+// Saves between BeginSync and CommitSync must not be visible to readers before CommitSync returns; AbortSync discards
+// them.
+type syncTransactor interface {
+	BeginSync() error
+	CommitSync() error
+	AbortSync() error
+}
+
 type fsStorage struct {
 	dataDir             string
 	doNotUseCompression bool
+	binaryFormat        bool
+	codec               atomic.Pointer[fsCodec]
+	dictLock            syncPkg.Mutex // serializes reloading the zstd dictionary, see readCodec
 	createDirsLock      syncPkg.Mutex
 	lockMapLock         syncPkg.Mutex
-	fileLocks           map[string]*syncPkg.RWMutex // prefix -> lock
+	fileLocks           map[string]*fileLock // prefix -> lock, only while in use
+	fileLockPool        syncPkg.Pool         // recycles the locks removed from fileLocks
+	cache               *rangeCache          // nil if caching is disabled, see WithCache
+}
+
+// bufReaderPool holds the buffered readers used for reading range files, so reading does not allocate them anew.
+var bufReaderPool = syncPkg.Pool{
+	New: func() any {
+		// 64KB buffer - this should fit the whole file
+		return bufio.NewReaderSize(nil, 64*1024)
+	},
+}
+
+func getBufReader(r io.Reader) *bufio.Reader {
+	bufReader := bufReaderPool.Get().(*bufio.Reader)
+	bufReader.Reset(r)
+
+	return bufReader
 }
 
-var _ storage = (*fsStorage)(nil)
+func putBufReader(bufReader *bufio.Reader) {
+	bufReader.Reset(nil)
+	bufReaderPool.Put(bufReader)
+}
+
+var (
+	_ Storage         = (*fsStorage)(nil)
+	_ sizer           = (*fsStorage)(nil)
+	_ leftoverCleaner = (*fsStorage)(nil)
+	_ suffixLookuper  = (*fsStorage)(nil)
+	_ rangeLoader     = (*fsStorage)(nil)
+)
+
+// NewFSStorage creates the file-based storage, which is used by default.
+// It stores one file per range, grouped into 256 directories, below the given directory.
+// Only the options regarding the storage format, e.g., WithoutCompression or WithBinaryFormat, and WithCache are taken
+// into account.
+func NewFSStorage(dataDir string, options ...CommonOption) (Storage, error) {
+	config := commonConfig{}
+
+	for _, option := range options {
+		option(&config)
+	}
+
+	f, err := newFSStorageFromConfig(dataDir, config)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.cacheSize > 0 {
+		f.cache = newRangeCache(config.cacheSize)
+	}
+
+	return f, nil
+}
+
+// newFSStorageFromConfig creates the file-based storage, picking up the zstd dictionary of the data dir, if any.
+func newFSStorageFromConfig(dataDir string, config commonConfig) (*fsStorage, error) {
+	f := newFSStorage(dataDir, config.noCompression)
+	f.binaryFormat = config.binaryFormat
+
+	dict, err := os.ReadFile(path.Join(dataDir, hibpDictionaryPath))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("reading zstd dictionary: %w", err)
+	}
+
+	if err := f.configureCodec(config.level, dict); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
 
 func newFSStorage(dataDir string, doNotUseCompression bool) *fsStorage {
-	return &fsStorage{
+	f := &fsStorage{
 		dataDir:             dataDir,
 		doNotUseCompression: doNotUseCompression,
-		fileLocks:           make(map[string]*syncPkg.RWMutex),
+		fileLocks:           make(map[string]*fileLock),
+	}
+
+	f.fileLockPool.New = f.newFileLock
+	f.codec.Store(&fsCodec{})
+
+	return f
+}
+
+// fsCodec holds the options of the zstd encoders and decoders used by fsStorage.
+type fsCodec struct {
+	level          int
+	dictID         uint32 // 0 if there is no dictionary
+	encoderOptions []zstd.EOption
+	decoderOptions []zstd.DOption
+	decoders       syncPkg.Pool
+}
+
+// decoder returns a pooled zstd decoder reading from r, it has to be returned using releaseDecoder.
+// Decoders run synchronously, i.e., without goroutines of their own, so dropping them from the pool is fine.
+func (c *fsCodec) decoder(r io.Reader) (*zstd.Decoder, error) {
+	if dec, ok := c.decoders.Get().(*zstd.Decoder); ok {
+		if err := dec.Reset(r); err != nil {
+			return nil, fmt.Errorf("resetting zstd reader: %w", err)
+		}
+
+		return dec, nil
+	}
+
+	dec, err := zstd.NewReader(r, append([]zstd.DOption{zstd.WithDecoderConcurrency(1)}, c.decoderOptions...)...)
+	if err != nil {
+		return nil, fmt.Errorf("creating zstd reader: %w", err)
+	}
+
+	return dec, nil
+}
+
+func (c *fsCodec) releaseDecoder(dec *zstd.Decoder) {
+	// Resetting drops the reference to the underlying reader
+	if err := dec.Reset(nil); err == nil {
+		c.decoders.Put(dec)
+	}
+}
+
+// configureCodec sets the compression level and the dictionary used for ranges written from now on.
+// Ranges that have been written without the dictionary remain readable, as zstd frames refer to the dictionary they
+// require by its ID.
+func (f *fsStorage) configureCodec(level int, dict []byte) error {
+	codec := &fsCodec{level: level}
+
+	if level != 0 {
+		codec.encoderOptions = append(codec.encoderOptions, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+	}
+
+	if dict != nil {
+		codec.encoderOptions = append(codec.encoderOptions, zstd.WithEncoderDict(dict))
+		codec.decoderOptions = append(codec.decoderOptions, zstd.WithDecoderDicts(dict))
+
+		// Fail early instead of with every range
+		d, err := zstd.InspectDictionary(dict)
+		if err != nil {
+			return fmt.Errorf("loading zstd dictionary: %w", err)
+		}
+
+		codec.dictID = d.ID()
+	}
+
+	f.codec.Store(codec)
+
+	return nil
+}
+
+// readCodec returns the codec to decode the given file with.
+// A range referring to a dictionary unknown to the storage has been written by another process that trained the
+// dictionary in the meantime, see TrainDictionary, so the dictionary is reloaded from the data dir.
+func (f *fsStorage) readCodec(rf *rangeFile) (*fsCodec, error) {
+	codec := f.codec.Load()
+	if rf.codec != codecZstd {
+		return codec, nil
+	}
+
+	// Malformed frames are reported when decoding them
+	var header zstd.Header
+	if frame, _ := rf.r.Peek(zstd.HeaderMaxSize); header.Decode(frame) != nil || header.DictionaryID == 0 || header.DictionaryID == codec.dictID {
+		return codec, nil
+	}
+
+	f.dictLock.Lock()
+	defer f.dictLock.Unlock()
+
+	// Someone else might have reloaded the dictionary while we were waiting
+	if codec = f.codec.Load(); header.DictionaryID == codec.dictID {
+		return codec, nil
+	}
+
+	dict, err := os.ReadFile(path.Join(f.dataDir, hibpDictionaryPath))
+	if errors.Is(err, os.ErrNotExist) {
+		return codec, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("reading zstd dictionary: %w", err)
+	}
+
+	if err := f.configureCodec(codec.level, dict); err != nil {
+		return nil, err
 	}
+
+	return f.codec.Load(), nil
+}
+
+// content returns a reader for the decoded content of the given file, see rangeFile.content.
+func (f *fsStorage) content(rf *rangeFile) (io.Reader, error) {
+	codec, err := f.readCodec(rf)
+	if err != nil {
+		return nil, err
+	}
+
+	return rf.content(codec)
 }
 
 type lockType int
@@ -48,30 +272,67 @@ const (
 	tmpSuffix = ".tmp"
 )
 
+// fileLock guards a single range file.
+// It is only kept in fsStorage.fileLocks while somebody holds or waits for it, so the map does not grow to the
+// number of files (approx. 1 million) over the lifetime of the process.
+type fileLock struct {
+	syncPkg.RWMutex
+	key  string
+	refs int // guarded by fsStorage.lockMapLock
+
+	// The functions releasing the lock are created once per lock, so locking does not allocate.
+	unlock, runlock func()
+}
+
+func (f *fsStorage) newFileLock() any {
+	lock := &fileLock{}
+	lock.unlock = func() {
+		lock.Unlock()
+		f.releaseFileLock(lock)
+	}
+	lock.runlock = func() {
+		lock.RUnlock()
+		f.releaseFileLock(lock)
+	}
+
+	return lock
+}
+
 func (f *fsStorage) lockFile(key string, t lockType) func() {
 	f.lockMapLock.Lock()
-	fileLock, exists := f.fileLocks[key]
+	lock, exists := f.fileLocks[key]
 	if !exists {
-		fileLock = &syncPkg.RWMutex{}
-
-		// We cannot easily clean up the map of locks, because we would need to ensure nobody else is using
-		// the lock at that moment.
-		// This could be checked by trying to lock it, but would require us to lock fileLock while holding the
-		// lockMapLock - effectively resulting in a global lock over all files.
-		// Therefore, we consider it okay for this map to grow.
-		// The upper limit is the number of files, which is 0xFFFFF (approx. 1 million files).
-		// Additionally, this has the advantage of requiring fewer allocations and fewer objects to be gc'ed.
-		f.fileLocks[key] = fileLock
+		lock = f.fileLockPool.Get().(*fileLock)
+		lock.key = key
+		f.fileLocks[key] = lock
 	}
+	// Counting the reference before acquiring the lock ensures the lock is not removed while we are waiting for it
+	lock.refs++
 	f.lockMapLock.Unlock()
 
 	if t == write {
-		fileLock.Lock()
-		return fileLock.Unlock
+		lock.Lock()
+		return lock.unlock
 	}
 
-	fileLock.RLock()
-	return fileLock.RUnlock
+	lock.RLock()
+	return lock.runlock
+}
+
+// releaseFileLock drops a reference to the lock of a file, removing the lock once it is not in use anymore.
+func (f *fsStorage) releaseFileLock(lock *fileLock) {
+	f.lockMapLock.Lock()
+	defer f.lockMapLock.Unlock()
+
+	lock.refs--
+	if lock.refs > 0 {
+		return
+	}
+
+	// Nobody holds or waits for the lock, as that would require a reference
+	delete(f.fileLocks, lock.key)
+	lock.key = ""
+	f.fileLockPool.Put(lock)
 }
 
 func (f *fsStorage) Save(key, etag string, data []byte) error {
@@ -99,14 +360,27 @@ func (f *fsStorage) Save(key, etag string, data []byte) error {
 	defer closeOnce()
 
 	var (
-		w   io.Writer = file
-		enc *zstd.Encoder
+		w     io.Writer = file
+		enc   *zstd.Encoder
+		codec = f.writeCodec()
 	)
 
-	// We use the default compression level as non-scientific tests have shown that it's by far the best trade-off
-	// between compression ratio and speed.
-	if !f.doNotUseCompression {
-		enc, err = zstd.NewWriter(file)
+	if codec == codecBinary {
+		if data, err = encodeBinaryRange(data); err != nil {
+			return fmt.Errorf("encoding range %q: %w", key, err)
+		}
+	}
+
+	// The header is never compressed, so the codec can be detected when reading
+	if _, err := file.Write(encodeRangeFileHeader(codec)); err != nil {
+		return fmt.Errorf("writing header to file %q: %w", filePathTmp, err)
+	}
+
+	// The compression level and the dictionary, if any, are taken from the codec, see WithCompressionLevel and
+	// TrainDictionary; the level defaults to zstd's default as non-scientific tests have shown that it's by far the
+	// best trade-off between compression ratio and speed.
+	if codec == codecZstd {
+		enc, err = zstd.NewWriter(file, f.codec.Load().encoderOptions...)
 		if err != nil {
 			return fmt.Errorf("creating zstd writer: %w", err)
 		}
@@ -146,6 +420,10 @@ func (f *fsStorage) Save(key, etag string, data []byte) error {
 		return fmt.Errorf("renaming tmp file %q into actual file %q: %w", filePathTmp, filePath, err)
 	}
 
+	if f.cache != nil {
+		f.cache.invalidate(f, key)
+	}
+
 	return nil
 }
 
@@ -164,27 +442,33 @@ func (f *fsStorage) LoadETag(key string) (string, error) {
 
 	defer f.lockFile(key, read)()
 
-	file, err := os.Open(f.filePath(key))
+	rf, err := f.openRangeFile(key)
 	if err != nil {
-		return "", fmt.Errorf("opening file %q: %w", f.filePath(key), err)
+		return "", err
 	}
-	defer file.Close()
-
-	var r io.Reader = file
-
-	if !f.doNotUseCompression {
-		dec, err := zstd.NewReader(file)
-		if err != nil {
-			return "", fmt.Errorf("creating zstd reader: %w", err)
+	defer rf.Close()
+
+	// Syncs check the ETags of all ranges, so we only peek at the cache: loading the ranges into it would decode
+	// every single one of them and evict the ones actually in use.
+	if f.cache != nil {
+		if info, err := rf.file.Stat(); err == nil {
+			if cached, ok := f.cache.peek(f, key, info); ok {
+				return cached.etag, nil
+			}
 		}
-		defer dec.Close()
+	}
 
-		r = dec
+	r, err := f.content(rf)
+	if err != nil {
+		return "", err
 	}
 
-	etag, err := bufio.NewReader(r).ReadString('\n')
+	bufReader := getBufReader(r)
+	defer putBufReader(bufReader)
+
+	etag, err := bufReader.ReadString('\n')
 	if err != nil {
-		return "", fmt.Errorf("reading etag from file %q: %w", f.filePath(key), err)
+		return "", fmt.Errorf("reading etag from %s file %q: %w", rf.codec, f.filePath(key), err)
 	}
 
 	// Remove the newline character from the etag
@@ -192,6 +476,14 @@ func (f *fsStorage) LoadETag(key string) (string, error) {
 }
 
 func (f *fsStorage) LoadData(key string) (io.ReadCloser, error) {
+	_, reader, err := f.LoadRange(key)
+
+	return reader, err
+}
+
+// LoadRange returns the ETag of a range and a reader for its data, both read from the same file, i.e., the same
+// version of the range.
+func (f *fsStorage) LoadRange(key string) (string, io.ReadCloser, error) {
 	callerWillCleanupResources := false
 
 	key = strings.ToUpper(key)
@@ -203,68 +495,381 @@ func (f *fsStorage) LoadData(key string) (io.ReadCloser, error) {
 		}
 	}()
 
-	file, err := os.Open(f.filePath(key))
+	if f.cache != nil {
+		cached, err := f.loadCached(key)
+		if err != nil {
+			return "", nil, err
+		}
+
+		return cached.etag, io.NopCloser(bytes.NewReader(cached.data)), nil
+	}
+
+	rf, err := f.openRangeFile(key)
 	if err != nil {
-		return nil, fmt.Errorf("opening file %q: %w", f.filePath(key), err)
+		return "", nil, err
 	}
 
 	defer func() {
 		if !callerWillCleanupResources {
-			_ = file.Close()
+			_ = rf.Close()
 		}
 	}()
 
-	var (
-		r   io.Reader = file
-		dec *zstd.Decoder
-	)
-
-	if !f.doNotUseCompression {
-		dec, err = zstd.NewReader(file)
+	// The text format of ranges stored in the binary format is rendered into memory as a whole, i.e., the file is not
+	// needed anymore after returning.
+	if rf.codec == codecBinary {
+		etag, data, err := f.readBinaryRange(key, rf)
 		if err != nil {
-			return nil, fmt.Errorf("creating zstd reader: %w", err)
+			return "", nil, err
 		}
 
-		defer func() {
-			if !callerWillCleanupResources {
-				dec.Close()
-			}
-		}()
+		return etag, io.NopCloser(bytes.NewReader(data)), nil
+	}
 
-		r = dec
+	r, err := f.content(rf)
+	if err != nil {
+		return "", nil, err
 	}
 
-	// Create a new buffered reader for efficient reading
-	bufReader := bufio.NewReaderSize(r, 64*1024) // 64KB buffer - this should fit the whole file
+	// Use a buffered reader for efficient reading
+	bufReader := getBufReader(r)
+
+	// The first line contains the etag
+	etag, err := bufReader.ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		putBufReader(bufReader)
 
-	// Skip the first line containing the etag
-	if _, _, err := bufReader.ReadLine(); err != nil && !errors.Is(err, io.EOF) {
-		return nil, fmt.Errorf("skipping etag line in file %q: %w", f.filePath(key), err)
+		return "", nil, fmt.Errorf("reading etag from %s file %q: %w", rf.codec, f.filePath(key), err)
 	}
 
 	callerWillCleanupResources = true
 
-	return &closableReader{
+	return strings.TrimSuffix(etag, "\n"), &closableReader{
 		Reader: bufReader,
 		closeFn: func() error {
 			defer unlockFileFn()
-			defer file.Close()
-			if dec != nil {
-				defer dec.Close()
-			}
+			defer putBufReader(bufReader)
 
-			return nil
+			return rf.Close()
 		},
 	}, nil
 }
 
+// readBinaryRange reads the ETag of a range stored in the binary format and renders the text format of its data.
+func (f *fsStorage) readBinaryRange(key string, rf *rangeFile) (string, []byte, error) {
+	raw, err := io.ReadAll(rf.r)
+	if err != nil {
+		return "", nil, fmt.Errorf("reading file %q: %w", f.filePath(key), err)
+	}
+
+	etag, encoded, found := bytes.Cut(raw, []byte("\n"))
+	if !found {
+		return "", nil, fmt.Errorf("file %q is missing its etag", f.filePath(key))
+	}
+
+	data, err := renderBinaryRange(encoded)
+	if err != nil {
+		return "", nil, fmt.Errorf("rendering binary file %q: %w", f.filePath(key), err)
+	}
+
+	return string(etag), data, nil
+}
+
+// loadCached returns the ETag and the data of a range from the cache, loading it from disk if necessary.
+// The caller has to hold the read lock of the range.
+func (f *fsStorage) loadCached(key string) (*rangeCacheEntry, error) {
+	filePath := f.filePath(key)
+
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("opening file %q: %w", filePath, err)
+	}
+
+	if cached, ok := f.cache.get(f, key, info); ok {
+		return cached, nil
+	}
+
+	rf, err := f.openRangeFile(key)
+	if err != nil {
+		return nil, err
+	}
+	defer rf.Close()
+
+	var (
+		etag string
+		data []byte
+	)
+
+	if rf.codec == codecBinary {
+		if etag, data, err = f.readBinaryRange(key, rf); err != nil {
+			return nil, err
+		}
+	} else {
+		r, err := f.content(rf)
+		if err != nil {
+			return nil, err
+		}
+
+		content, err := io.ReadAll(r)
+		if err != nil {
+			return nil, fmt.Errorf("reading %s file %q: %w", rf.codec, filePath, err)
+		}
+
+		etagLine, rest, found := bytes.Cut(content, []byte("\n"))
+		if !found {
+			return nil, fmt.Errorf("reading etag from %s file %q: %w", rf.codec, filePath, io.ErrUnexpectedEOF)
+		}
+
+		etag, data = string(etagLine), rest
+	}
+
+	f.cache.put(f, key, etag, data, info)
+
+	return &rangeCacheEntry{etag: etag, data: data}, nil
+}
+
+// LookupSuffix looks up a single suffix within a range.
+// Ranges stored in the binary format are searched using binary search, others are scanned line by line.
+func (f *fsStorage) LookupSuffix(key, suffix string) (int64, bool, error) {
+	key = strings.ToUpper(key)
+
+	defer f.lockFile(key, read)()
+
+	if f.cache != nil {
+		cached, err := f.loadCached(key)
+		if err != nil {
+			return 0, false, err
+		}
+
+		return scanRangeData(cached.data, strings.ToUpper(suffix))
+	}
+
+	rf, err := f.openRangeFile(key)
+	if err != nil {
+		return 0, false, err
+	}
+	defer rf.Close()
+
+	r, err := f.content(rf)
+	if err != nil {
+		return 0, false, err
+	}
+
+	bufReader := getBufReader(r)
+	defer putBufReader(bufReader)
+
+	// Only the length of the etag is of interest, so there is no need to copy it
+	etag, err := bufReader.ReadSlice('\n')
+	if err != nil {
+		return 0, false, fmt.Errorf("reading etag from %s file %q: %w", rf.codec, f.filePath(key), err)
+	}
+
+	if rf.codec != codecBinary {
+		return scanRange(bufReader, strings.ToUpper(suffix))
+	}
+
+	count, found, err := lookupBinaryRange(rf.file, int64(rf.headerSize+len(etag)), strings.ToUpper(suffix))
+	if err != nil {
+		return 0, false, fmt.Errorf("looking up suffix in file %q: %w", f.filePath(key), err)
+	}
+
+	return count, found, nil
+}
+
+// rangeFile is an opened range file whose codec has been detected, see detectRangeCodec.
+type rangeFile struct {
+	file       *os.File
+	r          *bufio.Reader // positioned after the header
+	codec      rangeCodec
+	headerSize int
+	dec        *zstd.Decoder
+	decCodec   *fsCodec // the codec dec has to be returned to
+}
+
+func (f *fsStorage) openRangeFile(key string) (*rangeFile, error) {
+	file, err := os.Open(f.filePath(key))
+	if err != nil {
+		return nil, fmt.Errorf("opening file %q: %w", f.filePath(key), err)
+	}
+
+	r := getBufReader(file)
+
+	codec, headerSize, err := detectRangeCodec(r)
+	if err != nil {
+		putBufReader(r)
+		_ = file.Close()
+		return nil, fmt.Errorf("detecting format of file %q: %w", f.filePath(key), err)
+	}
+
+	return &rangeFile{
+		file:       file,
+		r:          r,
+		codec:      codec,
+		headerSize: headerSize,
+	}, nil
+}
+
+// content returns a reader for the decoded content of the file, i.e., the ETag line followed by the data.
+// Data in the binary format is returned as is.
+func (rf *rangeFile) content(codec *fsCodec) (io.Reader, error) {
+	if rf.codec != codecZstd {
+		return rf.r, nil
+	}
+
+	dec, err := codec.decoder(rf.r)
+	if err != nil {
+		return nil, err
+	}
+
+	rf.dec = dec
+	rf.decCodec = codec
+
+	return dec, nil
+}
+
+func (rf *rangeFile) Close() error {
+	if rf.dec != nil {
+		rf.decCodec.releaseDecoder(rf.dec)
+	}
+
+	putBufReader(rf.r)
+
+	return rf.file.Close()
+}
+
+// writeCodec returns the codec ranges are written with.
+// Reading does not depend on it, as the codec is detected per file.
+func (f *fsStorage) writeCodec() rangeCodec {
+	switch {
+	case f.binaryFormat:
+		return codecBinary
+	case f.doNotUseCompression:
+		return codecPlain
+	default:
+		return codecZstd
+	}
+}
+
+func (f *fsStorage) useCompression() bool {
+	return f.writeCodec() == codecZstd
+}
+
+func (f *fsStorage) Size(key string) (int64, error) {
+	key = strings.ToUpper(key)
+
+	defer f.lockFile(key, read)()
+
+	info, err := os.Stat(f.filePath(key))
+	if err != nil {
+		return 0, fmt.Errorf("getting size of file %q: %w", f.filePath(key), err)
+	}
+
+	return info.Size(), nil
+}
+
+// rangeKeys returns the keys of all ranges stored, in ascending order.
+func (f *fsStorage) rangeKeys() ([]string, error) {
+	subDirs, err := f.rangeDirs()
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []string
+
+	for _, subDir := range subDirs {
+		files, err := os.ReadDir(path.Join(f.dataDir, subDir))
+		if err != nil {
+			return nil, fmt.Errorf("listing directory %q: %w", subDir, err)
+		}
+
+		for _, file := range files {
+			if key := strings.ToUpper(subDir + file.Name()); len(key) == prefixLength && !file.IsDir() {
+				keys = append(keys, key)
+			}
+		}
+	}
+
+	return keys, nil
+}
+
+// Leftovers returns the temporary files that have been left behind by interrupted calls to Save.
+func (f *fsStorage) Leftovers() ([]string, error) {
+	subDirs, err := f.rangeDirs()
+	if err != nil {
+		return nil, err
+	}
+
+	var leftovers []string
+
+	for _, subDir := range subDirs {
+		files, err := os.ReadDir(path.Join(f.dataDir, subDir))
+		if err != nil {
+			return nil, fmt.Errorf("listing directory %q: %w", subDir, err)
+		}
+
+		for _, file := range files {
+			if strings.HasSuffix(file.Name(), tmpSuffix) {
+				leftovers = append(leftovers, path.Join(f.dataDir, subDir, file.Name()))
+			}
+		}
+	}
+
+	return leftovers, nil
+}
+
+// rangeDirs returns the names of the directories holding the range files.
+// Only these are of interest, e.g., the NTLM dataset is stored in a sub-directory of the data dir as well.
+func (f *fsStorage) rangeDirs() ([]string, error) {
+	entries, err := os.ReadDir(f.dataDir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+
+		return nil, fmt.Errorf("listing data directory %q: %w", f.dataDir, err)
+	}
+
+	var subDirs []string
+
+	for _, entry := range entries {
+		if _, err := hex.DecodeString(entry.Name()); entry.IsDir() && len(entry.Name()) == 2 && err == nil {
+			subDirs = append(subDirs, entry.Name())
+		}
+	}
+
+	return subDirs, nil
+}
+
+// RemoveLeftovers removes the temporary files that have been left behind by interrupted calls to Save.
+func (f *fsStorage) RemoveLeftovers() error {
+	leftovers, err := f.Leftovers()
+	if err != nil {
+		return err
+	}
+
+	for _, leftover := range leftovers {
+		key := strings.ToUpper(path.Base(path.Dir(leftover)) + strings.TrimSuffix(path.Base(leftover), tmpSuffix))
+
+		if err := func() error {
+			// Holding the write lock ensures we do not remove a file that is about to be written
+			defer f.lockFile(key, write)()
+
+			return os.Remove(leftover)
+		}(); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("removing %q: %w", leftover, err)
+		}
+	}
+
+	return nil
+}
+
 func (f *fsStorage) subDir(key string) string {
 	subDir := key[:2]
 	return path.Join(f.dataDir, subDir)
 }
 
 func (f *fsStorage) filePath(key string) string {
-	return path.Join(f.subDir(key), key[2:])
+	return path.Join(f.dataDir, key[:2], key[2:])
 }
 
 type closableReader struct {
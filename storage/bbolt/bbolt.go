@@ -0,0 +1,315 @@
+// Package bbolt provides a storage for the HIBP dataset built on the embedded key-value store bbolt.
+//
+// Instead of storing ranges as a whole, every hash is stored as a key of its own, i.e., its raw bytes, with its count
+// encoded as varint as value.
+// Looking up a single hash, e.g., using HIBP.Lookup, therefore is a single B-tree lookup.
+// Ranges are reconstructed by iterating the keys sharing the prefix of the range.
+package bbolt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	hibp "github.com/exaring/go-hibp-sync"
+	bolt "go.etcd.io/bbolt"
+	"io"
+	"io/fs"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// hashesBucket maps the raw bytes of every hash to its count.
+	hashesBucket = []byte("hashes")
+	// rangesBucket maps the prefix of every range to its metadata, see rangeMeta.
+	rangesBucket = []byte("ranges")
+)
+
+const (
+	// flagTrailingCRLF records that the last line of the range is terminated by CRLF as well.
+	flagTrailingCRLF = 1 << 0
+)
+
+var crlf = []byte("\r\n")
+
+// Storage is a hibp.Storage keeping every hash as a key of its own in a bbolt database.
+type Storage struct {
+	db *bolt.DB
+}
+
+var _ hibp.Storage = (*Storage)(nil)
+
+// New opens the bbolt database at the given path, creating it if it does not exist.
+// bbolt locks the database file, i.e., only a single process can use it at a time.
+// The storage has to be closed using Close.
+func New(path string) (*Storage, error) {
+	db, err := bolt.Open(path, 0o644, &bolt.Options{Timeout: 10 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("opening database %q: %w", path, err)
+	}
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{hashesBucket, rangesBucket} {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("creating buckets: %w", err)
+	}
+
+	return &Storage{db: db}, nil
+}
+
+// Save replaces all hashes of the range within a single transaction.
+// Concurrent saves are batched into shared transactions.
+// Data that cannot be reconstructed exactly from its hashes, e.g., because it is not sorted, is rejected.
+func (s *Storage) Save(key, etag string, data []byte) error {
+	key = strings.ToUpper(key)
+
+	hashes, counts, flags, err := parseRange(key, data)
+	if err != nil {
+		return fmt.Errorf("parsing range %q: %w", key, err)
+	}
+
+	if rendered := renderRange(key, hashes, counts, flags); !bytes.Equal(rendered, data) {
+		return fmt.Errorf("range %q cannot be reconstructed from its hashes", key)
+	}
+
+	meta := append([]byte{flags}, etag...)
+
+	// Batch may run the function more than once, which is fine as it replaces the range as a whole
+	if err := s.db.Batch(func(tx *bolt.Tx) error {
+		b := tx.Bucket(hashesBucket)
+
+		var existing [][]byte
+		if err := forEachHash(b, key, func(hash, _ []byte) error {
+			existing = append(existing, hash)
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		for _, hash := range existing {
+			if err := b.Delete(hash); err != nil {
+				return err
+			}
+		}
+
+		for i, hash := range hashes {
+			if err := b.Put(hash, binary.AppendUvarint(nil, counts[i])); err != nil {
+				return err
+			}
+		}
+
+		return tx.Bucket(rangesBucket).Put([]byte(key), meta)
+	}); err != nil {
+		return fmt.Errorf("saving range %q: %w", key, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) LoadETag(key string) (string, error) {
+	var etag string
+
+	if err := s.db.View(func(tx *bolt.Tx) error {
+		meta, err := loadMeta(tx, key)
+		if err != nil {
+			return err
+		}
+
+		etag = string(meta[1:])
+
+		return nil
+	}); err != nil {
+		return "", err
+	}
+
+	return etag, nil
+}
+
+// LoadData reconstructs the range from its hashes.
+// The range is rendered into memory as a whole, so replacing it does not affect the returned reader.
+func (s *Storage) LoadData(key string) (io.ReadCloser, error) {
+	key = strings.ToUpper(key)
+
+	var data []byte
+
+	if err := s.db.View(func(tx *bolt.Tx) error {
+		meta, err := loadMeta(tx, key)
+		if err != nil {
+			return err
+		}
+
+		var (
+			hashes [][]byte
+			counts []uint64
+		)
+
+		if err := forEachHash(tx.Bucket(hashesBucket), key, func(hash, value []byte) error {
+			count, n := binary.Uvarint(value)
+			if n <= 0 {
+				return fmt.Errorf("invalid count of hash %X", hash)
+			}
+
+			hashes = append(hashes, hash)
+			counts = append(counts, count)
+
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		data = renderRange(key, hashes, counts, meta[0])
+
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("loading range %q: %w", key, err)
+	}
+
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+// LookupSuffix looks up a single hash, given by the prefix of its range and its suffix.
+// It is used by HIBP.Lookup.
+func (s *Storage) LookupSuffix(key, suffix string) (int64, bool, error) {
+	hash, err := hex.DecodeString(key + suffix)
+	if err != nil {
+		return 0, false, fmt.Errorf("decoding hash: %w", err)
+	}
+
+	var (
+		count uint64
+		found bool
+	)
+
+	if err := s.db.View(func(tx *bolt.Tx) error {
+		value := tx.Bucket(hashesBucket).Get(hash)
+		if value == nil {
+			// The range itself has to exist for the hash to be considered absent
+			_, err := loadMeta(tx, key)
+			return err
+		}
+
+		var n int
+		if count, n = binary.Uvarint(value); n <= 0 {
+			return fmt.Errorf("invalid count of hash %X", hash)
+		}
+
+		found = true
+
+		return nil
+	}); err != nil {
+		return 0, false, err
+	}
+
+	return int64(count), found, nil
+}
+
+// Close closes the database.
+func (s *Storage) Close() error {
+	return s.db.Close()
+}
+
+func loadMeta(tx *bolt.Tx, key string) ([]byte, error) {
+	meta := tx.Bucket(rangesBucket).Get([]byte(strings.ToUpper(key)))
+	if meta == nil {
+		return nil, fmt.Errorf("range %q: %w", key, fs.ErrNotExist)
+	}
+
+	if len(meta) == 0 {
+		return nil, fmt.Errorf("range %q has invalid metadata", key)
+	}
+
+	return meta, nil
+}
+
+// forEachHash calls fn for all hashes of the given range in ascending order.
+// Prefixes consist of 5 hex characters, i.e., the hashes of a range share their first 2.5 bytes.
+func forEachHash(b *bolt.Bucket, key string, fn func(hash, value []byte) error) error {
+	start, err := hex.DecodeString(key + "0")
+	if err != nil {
+		return fmt.Errorf("invalid range %q: %w", key, err)
+	}
+
+	c := b.Cursor()
+
+	for k, v := c.Seek(start); k != nil && len(k) >= len(start) && bytes.Equal(k[:2], start[:2]) && k[2]>>4 == start[2]>>4; k, v = c.Next() {
+		if err := fn(k, v); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func parseRange(key string, data []byte) ([][]byte, []uint64, byte, error) {
+	var flags byte
+
+	lines := data
+	if bytes.HasSuffix(lines, crlf) {
+		flags |= flagTrailingCRLF
+		lines = lines[:len(lines)-len(crlf)]
+	}
+
+	var (
+		hashes [][]byte
+		counts []uint64
+	)
+
+	for len(lines) > 0 {
+		var line []byte
+		line, lines, _ = bytes.Cut(lines, crlf)
+
+		suffix, count, found := bytes.Cut(line, []byte(":"))
+		if !found {
+			return nil, nil, 0, fmt.Errorf("malformed line %q", line)
+		}
+
+		hash, err := hex.DecodeString(key + string(suffix))
+		if err != nil {
+			return nil, nil, 0, fmt.Errorf("invalid suffix %q: %w", suffix, err)
+		}
+
+		n, err := strconv.ParseUint(string(count), 10, 64)
+		if err != nil {
+			return nil, nil, 0, fmt.Errorf("invalid count in line %q: %w", line, err)
+		}
+
+		// The range is reconstructed in the order of the keys
+		if len(hashes) > 0 && bytes.Compare(hashes[len(hashes)-1], hash) >= 0 {
+			return nil, nil, 0, fmt.Errorf("suffix %q is out of order", suffix)
+		}
+
+		hashes = append(hashes, hash)
+		counts = append(counts, n)
+	}
+
+	return hashes, counts, flags, nil
+}
+
+func renderRange(key string, hashes [][]byte, counts []uint64, flags byte) []byte {
+	var buf bytes.Buffer
+
+	for i, hash := range hashes {
+		if i > 0 {
+			buf.Write(crlf)
+		}
+
+		buf.WriteString(strings.ToUpper(hex.EncodeToString(hash))[len(key):])
+		buf.WriteByte(':')
+		buf.WriteString(strconv.FormatUint(counts[i], 10))
+	}
+
+	if flags&flagTrailingCRLF != 0 {
+		buf.Write(crlf)
+	}
+
+	return buf.Bytes()
+}
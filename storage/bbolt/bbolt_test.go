@@ -0,0 +1,97 @@
+package bbolt_test
+
+import (
+	"context"
+	"errors"
+	hibp "github.com/exaring/go-hibp-sync"
+	"github.com/exaring/go-hibp-sync/storage/bbolt"
+	"github.com/exaring/go-hibp-sync/storagetest"
+	"io"
+	"io/fs"
+	"path"
+	"testing"
+)
+
+func TestStorageConformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) hibp.Storage {
+		return newStorage(t)
+	})
+}
+
+func TestLookup(t *testing.T) {
+	// SHA-1 of "password" is 5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8
+	const rangeData = "1E4C9B93F3F0682250B6CF8331B7EE68FD7:3\r\n1E4C9B93F3F0682250B6CF8331B7EE68FD8:10434004\r\n1E4C9B93F3F0682250B6CF8331B7EE68FD9:0"
+
+	store := newStorage(t)
+
+	h, err := hibp.New(hibp.WithDataDir(t.TempDir()), hibp.WithStorage(store))
+	if err != nil {
+		t.Fatalf("creating HIBP: %v", err)
+	}
+
+	// Neighbouring ranges must not leak into the range
+	for key, data := range map[string]string{
+		"5BAA5": "FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFF:1",
+		"5BAA6": rangeData,
+		"5BAA7": "00000000000000000000000000000000000:1",
+	} {
+		if err := store.Save(key, "etag", []byte(data)); err != nil {
+			t.Fatalf("saving range %q: %v", key, err)
+		}
+	}
+
+	count, found, err := h.CheckPassword("password")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !found || count != 10434004 {
+		t.Fatalf("unexpected result: found=%v, count=%d", found, count)
+	}
+
+	if _, found, err := h.Lookup(context.Background(), "5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD0"); err != nil || found {
+		t.Fatalf("expected hash to not be found, got found=%v, err=%v", found, err)
+	}
+
+	if _, _, err := h.Lookup(context.Background(), "000001E4C9B93F3F0682250B6CF8331B7EE68FD0"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected missing range, got %v", err)
+	}
+
+	reader, err := h.Query("5BAA6")
+	if err != nil {
+		t.Fatalf("querying: %v", err)
+	}
+	defer reader.Close()
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("reading: %v", err)
+	}
+
+	if string(data) != rangeData {
+		t.Fatalf("unexpected data: %q", data)
+	}
+}
+
+func TestRejectsUnsortedRanges(t *testing.T) {
+	store := newStorage(t)
+
+	if err := store.Save("00000", "etag", []byte("000A8DAE4228F821FB418F59826079BF368:4\r\n0005AD76BD555C1D6D771DE417A4B87E4B4:10")); err == nil {
+		t.Fatal("expected unsorted range to be rejected")
+	}
+}
+
+func newStorage(t *testing.T) *bbolt.Storage {
+	t.Helper()
+
+	store, err := bbolt.New(path.Join(t.TempDir(), "hibp.db"))
+	if err != nil {
+		t.Fatalf("creating storage: %v", err)
+	}
+
+	t.Cleanup(func() {
+		_ = store.Close()
+	})
+
+	return store
+}
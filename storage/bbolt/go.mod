@@ -0,0 +1,19 @@
+module github.com/exaring/go-hibp-sync/storage/bbolt
+
+go 1.21.7
+
+require (
+	github.com/exaring/go-hibp-sync v0.0.0
+	go.etcd.io/bbolt v1.3.9
+)
+
+require (
+	github.com/alitto/pond v1.8.3 // indirect
+	github.com/deckarep/golang-set/v2 v2.6.0 // indirect
+	github.com/hashicorp/go-cleanhttp v0.5.2 // indirect
+	github.com/hashicorp/go-retryablehttp v0.7.5 // indirect
+	github.com/klauspost/compress v1.17.6 // indirect
+	golang.org/x/sys v0.17.0 // indirect
+)
+
+replace github.com/exaring/go-hibp-sync => ../..
@@ -0,0 +1,144 @@
+// Package s3 provides a storage for the HIBP dataset keeping the ranges in an S3-compatible bucket.
+//
+// Objects follow the layout of the file-based storage, i.e., the range "ABCDE" is stored as object "AB/CDE".
+// Their content is the zstd-compressed data of the range; the ETag of the range is stored as object metadata.
+// This allows a single sync job to feed any number of readers sharing the bucket.
+package s3
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	hibp "github.com/exaring/go-hibp-sync"
+	"github.com/klauspost/compress/zstd"
+	"github.com/minio/minio-go/v7"
+	"io"
+	"io/fs"
+	"net/http"
+	"strings"
+)
+
+// metadataETag is the name of the object metadata holding the ETag of a range.
+const metadataETag = "Hibp-Etag"
+
+// Storage is a hibp.Storage keeping every range as an object of its own in an S3-compatible bucket.
+type Storage struct {
+	client    *minio.Client
+	bucket    string
+	keyPrefix string
+	enc       *zstd.Encoder
+	dec       *zstd.Decoder
+}
+
+var _ hibp.Storage = (*Storage)(nil)
+
+// Option represents a type of function that can be used to customize the storage.
+type Option func(s *Storage)
+
+// WithKeyPrefix sets a prefix prepended to the keys of all objects, e.g., to share a bucket with other data.
+// Default: ""; meaning objects are stored at the top level of the bucket
+func WithKeyPrefix(prefix string) Option {
+	return func(s *Storage) {
+		s.keyPrefix = prefix
+	}
+}
+
+// New creates a storage using the given, already configured client and bucket.
+// The bucket has to exist already.
+func New(client *minio.Client, bucket string, options ...Option) (*Storage, error) {
+	enc, err := zstd.NewWriter(nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating zstd writer: %w", err)
+	}
+
+	dec, err := zstd.NewReader(nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating zstd reader: %w", err)
+	}
+
+	s := &Storage{
+		client: client,
+		bucket: bucket,
+		enc:    enc,
+		dec:    dec,
+	}
+
+	for _, option := range options {
+		option(s)
+	}
+
+	return s, nil
+}
+
+// Save uploads the range; objects are replaced atomically by S3-compatible stores.
+func (s *Storage) Save(key, etag string, data []byte) error {
+	blob := s.enc.EncodeAll(data, nil)
+
+	if _, err := s.client.PutObject(context.Background(), s.bucket, s.objectKey(key), bytes.NewReader(blob), int64(len(blob)), minio.PutObjectOptions{
+		ContentType:  "application/zstd",
+		UserMetadata: map[string]string{metadataETag: etag},
+	}); err != nil {
+		return fmt.Errorf("uploading range %q: %w", key, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) LoadETag(key string) (string, error) {
+	info, err := s.client.StatObject(context.Background(), s.bucket, s.objectKey(key), minio.StatObjectOptions{})
+	if err != nil {
+		return "", s.wrapError(key, err)
+	}
+
+	return info.UserMetadata[metadataETag], nil
+}
+
+// LoadData downloads the range.
+// The range is read into memory as a whole, so replacing it does not affect the returned reader.
+func (s *Storage) LoadData(key string) (io.ReadCloser, error) {
+	object, err := s.client.GetObject(context.Background(), s.bucket, s.objectKey(key), minio.GetObjectOptions{})
+	if err != nil {
+		return nil, s.wrapError(key, err)
+	}
+	defer object.Close()
+
+	// The object is requested lazily, i.e., errors regarding the object surface when reading
+	blob, err := io.ReadAll(object)
+	if err != nil {
+		return nil, s.wrapError(key, err)
+	}
+
+	data, err := s.dec.DecodeAll(blob, nil)
+	if err != nil {
+		return nil, fmt.Errorf("decompressing range %q: %w", key, err)
+	}
+
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+// Size returns the number of bytes the object of the range occupies.
+func (s *Storage) Size(key string) (int64, error) {
+	info, err := s.client.StatObject(context.Background(), s.bucket, s.objectKey(key), minio.StatObjectOptions{})
+	if err != nil {
+		return 0, s.wrapError(key, err)
+	}
+
+	return info.Size, nil
+}
+
+func (s *Storage) objectKey(key string) string {
+	key = strings.ToUpper(key)
+
+	return s.keyPrefix + key[:2] + "/" + key[2:]
+}
+
+// wrapError translates missing objects into errors wrapping fs.ErrNotExist, as expected by hibp.Storage.
+func (s *Storage) wrapError(key string, err error) error {
+	var resp minio.ErrorResponse
+	if errors.As(err, &resp) && (resp.Code == "NoSuchKey" || resp.StatusCode == http.StatusNotFound) {
+		return fmt.Errorf("range %q: %w", key, errors.Join(fs.ErrNotExist, err))
+	}
+
+	return fmt.Errorf("loading range %q: %w", key, err)
+}
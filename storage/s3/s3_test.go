@@ -0,0 +1,166 @@
+package s3_test
+
+import (
+	"errors"
+	"fmt"
+	hibp "github.com/exaring/go-hibp-sync"
+	"github.com/exaring/go-hibp-sync/storage/s3"
+	"github.com/exaring/go-hibp-sync/storagetest"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	syncPkg "sync"
+	"testing"
+	"time"
+)
+
+const bucket = "hibp"
+
+func TestStorageConformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) hibp.Storage {
+		store, _ := newStorage(t)
+		return store
+	})
+}
+
+func TestObjectLayout(t *testing.T) {
+	store, fake := newStorage(t, s3.WithKeyPrefix("datasets/sha1/"))
+
+	if err := store.Save("abcde", `W/"0x8DC0A2B4D3D3F0A"`, []byte("suffix:1")); err != nil {
+		t.Fatalf("saving range: %v", err)
+	}
+
+	object, exists := fake.object("/" + bucket + "/datasets/sha1/AB/CDE")
+	if !exists {
+		t.Fatalf("expected object at the key of the range, got %v", fake.keys())
+	}
+
+	if etag := object.header.Get("X-Amz-Meta-Hibp-Etag"); etag != `W/"0x8DC0A2B4D3D3F0A"` {
+		t.Fatalf("expected the etag to be stored as metadata, got %q", etag)
+	}
+
+	etag, err := store.LoadETag("ABCDE")
+	if err != nil || etag != `W/"0x8DC0A2B4D3D3F0A"` {
+		t.Fatalf("unexpected etag: %q, %v", etag, err)
+	}
+
+	if _, err := store.LoadData("00000"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected missing range, got %v", err)
+	}
+}
+
+func newStorage(t *testing.T, options ...s3.Option) (*s3.Storage, *fakeS3) {
+	t.Helper()
+
+	fake := &fakeS3{objects: make(map[string]fakeObject)}
+
+	server := httptest.NewTLSServer(fake)
+	t.Cleanup(server.Close)
+
+	client, err := minio.New(server.Listener.Addr().String(), &minio.Options{
+		Creds:     credentials.NewStaticV4("access-key", "secret-key", ""),
+		Secure:    true,
+		Transport: server.Client().Transport,
+		Region:    "us-east-1",
+	})
+	if err != nil {
+		t.Fatalf("creating client: %v", err)
+	}
+
+	store, err := s3.New(client, bucket, options...)
+	if err != nil {
+		t.Fatalf("creating storage: %v", err)
+	}
+
+	return store, fake
+}
+
+// fakeS3 is a minimal, in-process stand-in for an S3-compatible store supporting uploading, downloading and
+// inspecting objects.
+// Authentication is not checked.
+type fakeS3 struct {
+	lock    syncPkg.Mutex
+	objects map[string]fakeObject // path -> object
+}
+
+type fakeObject struct {
+	header http.Header
+	data   []byte
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPut:
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		header := http.Header{}
+		for name, values := range r.Header {
+			if strings.HasPrefix(name, "X-Amz-Meta-") || name == "Content-Type" {
+				header[name] = values
+			}
+		}
+
+		header.Set("ETag", fmt.Sprintf(`"%x"`, len(data)))
+		header.Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+
+		f.lock.Lock()
+		f.objects[r.URL.Path] = fakeObject{header: header, data: data}
+		f.lock.Unlock()
+
+		w.Header().Set("ETag", header.Get("ETag"))
+	case http.MethodGet, http.MethodHead:
+		object, exists := f.object(r.URL.Path)
+		if !exists {
+			w.Header().Set("Content-Type", "application/xml")
+			w.WriteHeader(http.StatusNotFound)
+
+			if r.Method == http.MethodGet {
+				_, _ = io.WriteString(w, `<?xml version="1.0" encoding="UTF-8"?><Error><Code>NoSuchKey</Code><Message>The specified key does not exist.</Message></Error>`)
+			}
+
+			return
+		}
+
+		for name, values := range object.header {
+			w.Header()[name] = values
+		}
+
+		w.Header().Set("Content-Length", strconv.Itoa(len(object.data)))
+
+		if r.Method == http.MethodGet {
+			_, _ = w.Write(object.data)
+		}
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+func (f *fakeS3) object(path string) (fakeObject, bool) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	object, exists := f.objects[path]
+
+	return object, exists
+}
+
+func (f *fakeS3) keys() []string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	keys := make([]string, 0, len(f.objects))
+	for key := range f.objects {
+		keys = append(keys, key)
+	}
+
+	return keys
+}
@@ -0,0 +1,226 @@
+// Package sqlite provides a storage for the HIBP dataset keeping all ranges in a single SQLite database file.
+//
+// Ranges are stored in the table "ranges", keyed by their prefix, together with their ETag and the time they have
+// been synced at:
+//
+//	CREATE TABLE ranges (
+//		prefix    TEXT PRIMARY KEY,
+//		etag      TEXT NOT NULL,
+//		data      BLOB NOT NULL, -- zstd-compressed
+//		synced_at INTEGER NOT NULL -- seconds since the Unix epoch
+//	)
+//
+// The storage supports syncing atomically, see hibp.SyncAtomically.
+package sqlite
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	hibp "github.com/exaring/go-hibp-sync"
+	"github.com/klauspost/compress/zstd"
+	"io"
+	"io/fs"
+	_ "modernc.org/sqlite"
+	"strings"
+	syncPkg "sync"
+	"time"
+)
+
+const schema = `CREATE TABLE IF NOT EXISTS ranges (
+	prefix    TEXT PRIMARY KEY,
+	etag      TEXT NOT NULL,
+	data      BLOB NOT NULL,
+	synced_at INTEGER NOT NULL
+) WITHOUT ROWID`
+
+// Storage is a hibp.Storage keeping all ranges in a single SQLite database file.
+type Storage struct {
+	db  *sql.DB
+	enc *zstd.Encoder
+	dec *zstd.Decoder
+
+	// writeLock serializes writes, SQLite supports a single writer at a time anyway.
+	writeLock syncPkg.Mutex
+	// tx is the transaction of the atomic sync in progress, if any; it is guarded by writeLock.
+	tx *sql.Tx
+}
+
+var _ hibp.Storage = (*Storage)(nil)
+
+// New opens the SQLite database at the given path, creating it if it does not exist.
+// The database is switched to write-ahead logging, so reading is not blocked by writes.
+// The storage has to be closed using Close.
+func New(path string) (*Storage, error) {
+	db, err := sql.Open("sqlite", path+"?_pragma=busy_timeout(10000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, fmt.Errorf("opening database %q: %w", path, err)
+	}
+
+	if _, err := db.Exec(schema); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("creating schema: %w", err)
+	}
+
+	enc, err := zstd.NewWriter(nil)
+	if err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("creating zstd writer: %w", err)
+	}
+
+	dec, err := zstd.NewReader(nil)
+	if err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("creating zstd reader: %w", err)
+	}
+
+	return &Storage{
+		db:  db,
+		enc: enc,
+		dec: dec,
+	}, nil
+}
+
+// Save stores the range within a transaction; during an atomic sync, the transaction of the sync is used.
+func (s *Storage) Save(key, etag string, data []byte) error {
+	// The destination must not be nil, as empty ranges would be stored as NULL otherwise
+	blob := s.enc.EncodeAll(data, make([]byte, 0, len(data)/2))
+
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+
+	exec := s.db.Exec
+	if s.tx != nil {
+		exec = s.tx.Exec
+	}
+
+	if _, err := exec(`INSERT INTO ranges (prefix, etag, data, synced_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT (prefix) DO UPDATE SET etag = excluded.etag, data = excluded.data, synced_at = excluded.synced_at`,
+		strings.ToUpper(key), etag, blob, time.Now().Unix()); err != nil {
+		return fmt.Errorf("saving range %q: %w", key, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) LoadETag(key string) (string, error) {
+	var etag string
+
+	if err := s.queryRange(key, "etag", &etag); err != nil {
+		return "", err
+	}
+
+	return etag, nil
+}
+
+func (s *Storage) LoadData(key string) (io.ReadCloser, error) {
+	var blob []byte
+
+	if err := s.queryRange(key, "data", &blob); err != nil {
+		return nil, err
+	}
+
+	data, err := s.dec.DecodeAll(blob, nil)
+	if err != nil {
+		return nil, fmt.Errorf("decompressing range %q: %w", key, err)
+	}
+
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+// Size returns the number of bytes the compressed range occupies.
+func (s *Storage) Size(key string) (int64, error) {
+	var size int64
+
+	if err := s.queryRange(key, "length(data)", &size); err != nil {
+		return 0, err
+	}
+
+	return size, nil
+}
+
+// SyncedAt returns the point in time the range has been saved at.
+func (s *Storage) SyncedAt(key string) (time.Time, error) {
+	var seconds int64
+
+	if err := s.queryRange(key, "synced_at", &seconds); err != nil {
+		return time.Time{}, err
+	}
+
+	return time.Unix(seconds, 0), nil
+}
+
+// BeginSync starts an atomic sync: all ranges saved until CommitSync or AbortSync are part of a single transaction.
+// Readers keep seeing the previous state of the dataset until the transaction is committed.
+func (s *Storage) BeginSync() error {
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+
+	if s.tx != nil {
+		return errors.New("an atomic sync is in progress already")
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("beginning transaction: %w", err)
+	}
+
+	s.tx = tx
+
+	return nil
+}
+
+// CommitSync applies all ranges saved since BeginSync at once.
+func (s *Storage) CommitSync() error {
+	return s.endSync((*sql.Tx).Commit)
+}
+
+// AbortSync discards all ranges saved since BeginSync.
+func (s *Storage) AbortSync() error {
+	return s.endSync((*sql.Tx).Rollback)
+}
+
+// Close closes the database.
+func (s *Storage) Close() error {
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+
+	var txErr error
+	if s.tx != nil {
+		txErr = s.tx.Rollback()
+		s.tx = nil
+	}
+
+	s.dec.Close()
+
+	return errors.Join(txErr, s.enc.Close(), s.db.Close())
+}
+
+func (s *Storage) endSync(end func(tx *sql.Tx) error) error {
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+
+	if s.tx == nil {
+		return errors.New("no atomic sync in progress")
+	}
+
+	tx := s.tx
+	s.tx = nil
+
+	return end(tx)
+}
+
+// queryRange reads a single column of a range; the column is part of the query and must not be user-provided.
+func (s *Storage) queryRange(key, column string, dest any) error {
+	err := s.db.QueryRow("SELECT "+column+" FROM ranges WHERE prefix = ?", strings.ToUpper(key)).Scan(dest)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("range %q: %w", key, fs.ErrNotExist)
+	}
+
+	if err != nil {
+		return fmt.Errorf("loading range %q: %w", key, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,163 @@
+package sqlite_test
+
+import (
+	"errors"
+	hibp "github.com/exaring/go-hibp-sync"
+	"github.com/exaring/go-hibp-sync/storage/sqlite"
+	"github.com/exaring/go-hibp-sync/storagetest"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	syncPkg "sync"
+	"testing"
+)
+
+func TestStorageConformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) hibp.Storage {
+		return newStorage(t)
+	})
+}
+
+func TestSyncedAt(t *testing.T) {
+	store := newStorage(t)
+
+	if _, err := store.SyncedAt("00000"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected missing range, got %v", err)
+	}
+
+	if err := store.Save("00000", "etag", []byte("suffix:1")); err != nil {
+		t.Fatalf("saving range: %v", err)
+	}
+
+	syncedAt, err := store.SyncedAt("00000")
+	if err != nil {
+		t.Fatalf("loading timestamp: %v", err)
+	}
+
+	if syncedAt.IsZero() {
+		t.Fatal("expected a timestamp")
+	}
+}
+
+func TestAtomicSync(t *testing.T) {
+	var (
+		lock    syncPkg.Mutex
+		failing = true
+	)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		prefix := strings.TrimPrefix(r.URL.Path, "/range/")
+
+		// 404 is not retried by the HTTP client, which keeps the test fast
+		if prefix == "00001" && failing {
+			w.WriteHeader(http.StatusNotFound)
+
+			return
+		}
+
+		w.Header().Set("ETag", "etag")
+		_, _ = w.Write([]byte("suffix:" + prefix))
+	}))
+	defer server.Close()
+
+	store := newStorage(t)
+
+	h, err := hibp.New(hibp.WithDataDir(t.TempDir()), hibp.WithStorage(store))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	syncOptions := []hibp.SyncOption{
+		hibp.SyncWithEndpoint(server.URL + "/range/"),
+		hibp.SyncWithLastRange(2),
+		hibp.SyncWithMinWorkers(2),
+		hibp.SyncAtomically(),
+	}
+
+	var syncErr *hibp.SyncError
+	if err := h.Sync(syncOptions...); !errors.As(err, &syncErr) {
+		t.Fatalf("expected a SyncError, got: %v", err)
+	}
+
+	// None of the ranges that have been synced successfully must have been applied
+	for _, prefix := range []string{"00000", "00001", "00002"} {
+		if _, err := store.LoadETag(prefix); !errors.Is(err, fs.ErrNotExist) {
+			t.Fatalf("expected range %q to be missing, got %v", prefix, err)
+		}
+	}
+
+	lock.Lock()
+	failing = false
+	lock.Unlock()
+
+	if err := h.Sync(syncOptions...); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, prefix := range []string{"00000", "00001", "00002"} {
+		reader, err := h.Query(prefix)
+		if err != nil {
+			t.Fatalf("querying range %q: %v", prefix, err)
+		}
+
+		data, err := io.ReadAll(reader)
+		_ = reader.Close()
+
+		if err != nil || string(data) != "suffix:"+prefix {
+			t.Fatalf("unexpected data of range %q: %q, %v", prefix, data, err)
+		}
+	}
+
+	if h.MostRecentSuccessfulSync().IsZero() {
+		t.Fatal("expected the sync to count as successful")
+	}
+}
+
+func TestUncommittedSyncIsInvisible(t *testing.T) {
+	store := newStorage(t)
+
+	if err := store.BeginSync(); err != nil {
+		t.Fatalf("beginning sync: %v", err)
+	}
+
+	if err := store.Save("00000", "etag", []byte("suffix:1")); err != nil {
+		t.Fatalf("saving range: %v", err)
+	}
+
+	if _, err := store.LoadETag("00000"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected uncommitted range to be invisible, got %v", err)
+	}
+
+	if err := store.CommitSync(); err != nil {
+		t.Fatalf("committing sync: %v", err)
+	}
+
+	if etag, err := store.LoadETag("00000"); err != nil || etag != "etag" {
+		t.Fatalf("expected committed range, got %q, %v", etag, err)
+	}
+
+	if err := store.CommitSync(); err == nil {
+		t.Fatal("expected an error committing without a sync in progress")
+	}
+}
+
+func newStorage(t *testing.T) *sqlite.Storage {
+	t.Helper()
+
+	store, err := sqlite.New(path.Join(t.TempDir(), "hibp.db"))
+	if err != nil {
+		t.Fatalf("creating storage: %v", err)
+	}
+
+	t.Cleanup(func() {
+		_ = store.Close()
+	})
+
+	return store
+}
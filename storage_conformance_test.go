@@ -0,0 +1,48 @@
+package hibp_test
+
+import (
+	hibp "github.com/exaring/go-hibp-sync"
+	"github.com/exaring/go-hibp-sync/storagetest"
+	"testing"
+)
+
+func TestFSStorageConformance(t *testing.T) {
+	for name, options := range map[string][]hibp.CommonOption{
+		"with compression":    nil,
+		"without compression": {hibp.WithoutCompression()},
+		"binary format":       {hibp.WithBinaryFormat()},
+		"with cache":          {hibp.WithCache(1 << 20)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			storagetest.Run(t, func(t *testing.T) hibp.Storage {
+				store, err := hibp.NewFSStorage(t.TempDir(), options...)
+				if err != nil {
+					t.Fatalf("creating storage: %v", err)
+				}
+
+				return store
+			})
+		})
+	}
+}
+
+func TestPackStorageConformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) hibp.Storage {
+		store, err := hibp.NewPackStorage(t.TempDir())
+		if err != nil {
+			t.Fatalf("creating storage: %v", err)
+		}
+
+		t.Cleanup(func() {
+			_ = store.Close()
+		})
+
+		return store
+	})
+}
+
+func TestMemoryStorageConformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) hibp.Storage {
+		return hibp.NewMemoryStorage()
+	})
+}
@@ -4,9 +4,12 @@ import (
 	"bytes"
 	"github.com/klauspost/compress/zstd"
 	"io"
+	"math/rand"
 	"os"
 	"strings"
+	syncPkg "sync"
 	"testing"
+	"time"
 )
 
 func TestFSStorage(t *testing.T) {
@@ -35,6 +38,20 @@ func TestFSStorage(t *testing.T) {
 
 		reader = file
 
+		header := make([]byte, rangeFileHeaderSize)
+		if _, err := io.ReadFull(file, header); err != nil {
+			t.Fatalf("could not read header: %v", err)
+		}
+
+		expectedCodec := codecPlain
+		if useCompression {
+			expectedCodec = codecZstd
+		}
+
+		if !bytes.Equal(header, encodeRangeFileHeader(expectedCodec)) {
+			t.Fatalf("unexpected header: %q", header)
+		}
+
 		if useCompression {
 			dec, err := zstd.NewReader(file)
 			if err != nil {
@@ -90,3 +107,140 @@ func TestFSStorage(t *testing.T) {
 		testWriteRead(t, true)
 	})
 }
+
+func TestFSStorageFileLocks(t *testing.T) {
+	storage := newFSStorage(t.TempDir(), false)
+
+	if err := storage.Save("00000", "etag", []byte("ABC:1")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	reader, err := storage.LoadData("00000")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Saving the range has to wait until the reader is closed
+	saved := make(chan error)
+	go func() {
+		saved <- storage.Save("00000", "etag", []byte("ABC:2"))
+	}()
+
+	select {
+	case err := <-saved:
+		t.Fatalf("expected saving to wait for the reader, got %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if err := reader.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := <-saved; err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var wg syncPkg.WaitGroup
+
+	for i := 0; i < 8; i++ {
+		i := i
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			key := toRangeString(int64(i % 2))
+
+			for j := 0; j < 50; j++ {
+				if err := storage.Save(key, "etag", []byte("ABC:1")); err != nil {
+					t.Errorf("unexpected error: %v", err)
+					return
+				}
+
+				if _, err := storage.LoadETag(key); err != nil {
+					t.Errorf("unexpected error: %v", err)
+					return
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	// Locks are dropped once nobody uses them anymore
+	storage.lockMapLock.Lock()
+	defer storage.lockMapLock.Unlock()
+
+	if len(storage.fileLocks) != 0 {
+		t.Fatalf("expected no locks to be left, got %d", len(storage.fileLocks))
+	}
+}
+
+func BenchmarkFSStorageConcurrentAccess(b *testing.B) {
+	const ranges = 0x100
+
+	storage := newFSStorage(b.TempDir(), false)
+
+	for i := 0; i < ranges; i++ {
+		if err := storage.Save(toRangeString(int64(i)), "etag", []byte("ABC:1")); err != nil {
+			b.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	load := func(key string) error {
+		reader, err := storage.LoadData(key)
+		if err != nil {
+			return err
+		}
+
+		if _, err := io.Copy(io.Discard, reader); err != nil {
+			_ = reader.Close()
+
+			return err
+		}
+
+		return reader.Close()
+	}
+
+	for name, access := range map[string]func(rnd *rand.Rand, key string) error{
+		// Isolates the per-file locks, which are taken by every other access
+		"lock": func(rnd *rand.Rand, key string) error {
+			lockType := read
+			if rnd.Intn(10) == 0 {
+				lockType = write
+			}
+
+			storage.lockFile(key, lockType)()
+
+			return nil
+		},
+		"load": func(_ *rand.Rand, key string) error {
+			return load(key)
+		},
+		// Roughly mimics a sync running while the dataset is queried; the saves, i.e., their fsyncs, dominate
+		"load and save": func(rnd *rand.Rand, key string) error {
+			if rnd.Intn(10) == 0 {
+				return storage.Save(key, "etag", []byte("ABC:1"))
+			}
+
+			return load(key)
+		},
+	} {
+		access := access
+
+		b.Run(name, func(b *testing.B) {
+			b.RunParallel(func(pb *testing.PB) {
+				rnd := rand.New(rand.NewSource(rand.Int63()))
+
+				for pb.Next() {
+					// Failing the benchmark from within its workers is not allowed, reporting the error is
+					if err := access(rnd, toRangeString(int64(rnd.Intn(ranges)))); err != nil {
+						b.Errorf("unexpected error: %v", err)
+
+						return
+					}
+				}
+			})
+		})
+	}
+}
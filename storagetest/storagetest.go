@@ -0,0 +1,301 @@
+// Package storagetest provides a conformance test suite for implementations of hibp.Storage.
+// Implementations of custom storage backends should run it as part of their tests:
+//
+//	func TestMyStorage(t *testing.T) {
+//		storagetest.Run(t, func(t *testing.T) hibp.Storage {
+//			return newMyStorage(t.TempDir())
+//		})
+//	}
+package storagetest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	hibp "github.com/exaring/go-hibp-sync"
+	"io"
+	"io/fs"
+	syncPkg "sync"
+	"testing"
+)
+
+// Run runs the conformance test suite against the storage returned by newStorage.
+// newStorage is called once per test and has to return an empty storage; it is responsible for cleaning up
+// (e.g., using t.Cleanup).
+func Run(t *testing.T, newStorage func(t *testing.T) hibp.Storage) {
+	t.Helper()
+
+	t.Run("missing range", func(t *testing.T) {
+		testMissingRange(t, newStorage(t))
+	})
+
+	t.Run("save and load", func(t *testing.T) {
+		testSaveAndLoad(t, newStorage(t))
+	})
+
+	t.Run("replace", func(t *testing.T) {
+		testReplace(t, newStorage(t))
+	})
+
+	t.Run("data is copied", func(t *testing.T) {
+		testDataIsCopied(t, newStorage(t))
+	})
+
+	t.Run("concurrent saves of different ranges", func(t *testing.T) {
+		testConcurrentSaves(t, newStorage(t))
+	})
+
+	t.Run("atomic replace", func(t *testing.T) {
+		testAtomicReplace(t, newStorage(t))
+	})
+
+	t.Run("readers are not disturbed", func(t *testing.T) {
+		testReadersAreNotDisturbed(t, newStorage(t))
+	})
+}
+
+func testMissingRange(t *testing.T, store hibp.Storage) {
+	if _, err := store.LoadETag("00000"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected LoadETag to return fs.ErrNotExist, got: %v", err)
+	}
+
+	if _, err := store.LoadData("00000"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected LoadData to return fs.ErrNotExist, got: %v", err)
+	}
+}
+
+func testSaveAndLoad(t *testing.T, store hibp.Storage) {
+	ranges := map[string]struct {
+		etag string
+		data string
+	}{
+		"00000": {etag: `W/"0x8DC0A2B4D3D3F0A"`, data: "0005AD76BD555C1D6D771DE417A4B87E4B4:10\r\n000A8DAE4228F821FB418F59826079BF368:4"},
+		"ABCDE": {etag: "", data: "0018A45C4D1DEF81644B54AB7F969B88D65:1"},
+		"FFFFF": {etag: "etag", data: ""},
+	}
+
+	for key, r := range ranges {
+		if err := store.Save(key, r.etag, []byte(r.data)); err != nil {
+			t.Fatalf("saving range %q: %v", key, err)
+		}
+	}
+
+	for key, r := range ranges {
+		expectRange(t, store, key, r.etag, r.data)
+	}
+}
+
+func testReplace(t *testing.T, store hibp.Storage) {
+	if err := store.Save("00000", "etag1", []byte("0005AD76BD555C1D6D771DE417A4B87E4B4:10\r\n000A8DAE4228F821FB418F59826079BF368:4")); err != nil {
+		t.Fatalf("saving range: %v", err)
+	}
+
+	if err := store.Save("00000", "etag2", []byte("0005AD76BD555C1D6D771DE417A4B87E4B4:11")); err != nil {
+		t.Fatalf("replacing range: %v", err)
+	}
+
+	expectRange(t, store, "00000", "etag2", "0005AD76BD555C1D6D771DE417A4B87E4B4:11")
+}
+
+func testDataIsCopied(t *testing.T, store hibp.Storage) {
+	data := []byte("0005AD76BD555C1D6D771DE417A4B87E4B4:10")
+
+	if err := store.Save("00000", "etag", data); err != nil {
+		t.Fatalf("saving range: %v", err)
+	}
+
+	copy(data, "XXXXX")
+
+	expectRange(t, store, "00000", "etag", "0005AD76BD555C1D6D771DE417A4B87E4B4:10")
+}
+
+func testConcurrentSaves(t *testing.T, store hibp.Storage) {
+	const numRanges = 64
+
+	var wg syncPkg.WaitGroup
+
+	errs := make(chan error, numRanges)
+
+	for i := 0; i < numRanges; i++ {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			errs <- store.Save(fmt.Sprintf("%05X", i), fmt.Sprintf("etag%d", i), rangeData(i, 10))
+		}(i)
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("saving range: %v", err)
+		}
+	}
+
+	for i := 0; i < numRanges; i++ {
+		expectRange(t, store, fmt.Sprintf("%05X", i), fmt.Sprintf("etag%d", i), string(rangeData(i, 10)))
+	}
+}
+
+// testAtomicReplace replaces a range over and over again while it is read concurrently.
+// Readers must always observe one of the versions that have been written as a whole.
+func testAtomicReplace(t *testing.T, store hibp.Storage) {
+	const (
+		numVersions = 50
+		numReaders  = 4
+	)
+
+	versions := make(map[string]struct{}, numVersions)
+	for v := 0; v < numVersions; v++ {
+		versions[string(rangeData(v, 50+v*10))] = struct{}{}
+	}
+
+	if err := store.Save("00000", "etag", rangeData(0, 50)); err != nil {
+		t.Fatalf("saving range: %v", err)
+	}
+
+	var (
+		wg   syncPkg.WaitGroup
+		done = make(chan struct{})
+		errs = make(chan error, numReaders+1)
+	)
+
+	for r := 0; r < numReaders; r++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+
+				data, err := loadData(store, "00000")
+				if err != nil {
+					errs <- err
+					return
+				}
+
+				if _, exists := versions[string(data)]; !exists {
+					errs <- fmt.Errorf("read a torn version of the range with %d bytes", len(data))
+					return
+				}
+			}
+		}()
+	}
+
+	for v := 1; v < numVersions; v++ {
+		if err := store.Save("00000", fmt.Sprintf("etag%d", v), rangeData(v, 50+v*10)); err != nil {
+			errs <- err
+			break
+		}
+	}
+
+	close(done)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Fatal(err)
+	}
+}
+
+// testReadersAreNotDisturbed checks that a reader opened before a range gets replaced keeps reading the previous
+// version.
+// Implementations may either let Save wait for open readers to be closed or serve readers from a snapshot.
+func testReadersAreNotDisturbed(t *testing.T, store hibp.Storage) {
+	previous := rangeData(1, 1000)
+
+	if err := store.Save("00000", "etag1", previous); err != nil {
+		t.Fatalf("saving range: %v", err)
+	}
+
+	reader, err := store.LoadData("00000")
+	if err != nil {
+		t.Fatalf("loading range: %v", err)
+	}
+
+	// Read a little bit upfront, so lazy implementations have to commit to a version
+	head := make([]byte, 10)
+	if _, err := io.ReadFull(reader, head); err != nil {
+		t.Fatalf("reading range: %v", err)
+	}
+
+	saved := make(chan error, 1)
+
+	go func() {
+		saved <- store.Save("00000", "etag2", rangeData(2, 1000))
+	}()
+
+	rest, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("reading range: %v", err)
+	}
+
+	if err := reader.Close(); err != nil {
+		t.Fatalf("closing reader: %v", err)
+	}
+
+	if !bytes.Equal(append(head, rest...), previous) {
+		t.Fatalf("reader has been disturbed by a concurrent save")
+	}
+
+	if err := <-saved; err != nil {
+		t.Fatalf("replacing range: %v", err)
+	}
+
+	expectRange(t, store, "00000", "etag2", string(rangeData(2, 1000)))
+}
+
+func expectRange(t *testing.T, store hibp.Storage, key, etag, data string) {
+	t.Helper()
+
+	actualETag, err := store.LoadETag(key)
+	if err != nil {
+		t.Fatalf("loading etag of range %q: %v", key, err)
+	}
+
+	if actualETag != etag {
+		t.Fatalf("unexpected etag of range %q: %q", key, actualETag)
+	}
+
+	actualData, err := loadData(store, key)
+	if err != nil {
+		t.Fatalf("loading data of range %q: %v", key, err)
+	}
+
+	if string(actualData) != data {
+		t.Fatalf("unexpected data of range %q: %q", key, actualData)
+	}
+}
+
+func loadData(store hibp.Storage, key string) ([]byte, error) {
+	reader, err := store.LoadData(key)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	return io.ReadAll(reader)
+}
+
+// rangeData generates a syntactically valid range with the given number of lines, distinguishable by the seed.
+func rangeData(seed, lines int) []byte {
+	var buf bytes.Buffer
+
+	for i := 0; i < lines; i++ {
+		if i > 0 {
+			buf.WriteString("\r\n")
+		}
+
+		fmt.Fprintf(&buf, "%027X%08X:%d", i, seed, seed+1)
+	}
+
+	return buf.Bytes()
+}
@@ -7,28 +7,98 @@ import (
 	"github.com/alitto/pond"
 	mapset "github.com/deckarep/golang-set/v2"
 	"math"
+	"slices"
+	"strconv"
+	"strings"
 	syncPkg "sync"
 	"sync/atomic"
 )
 
-func sync(ctx context.Context, from, to int64, client *hibpClient, store storage, pool *pond.WorkerPool, onProgress ProgressFunc) error {
+// sync processes all ranges in [from, to) that are not marked as done in the given state.
+// The state gets updated as ranges are completed or fail.
+// Failing ranges do not stop the sync; they are reported as a *SyncError once all other ranges have been processed.
+// The outcome of every processed range is recorded in the given stats.
+func sync(ctx context.Context, from, to int64, client *hibpClient, store Storage, pool *pond.WorkerPool, state *syncState, stats *syncStats, onProgress ProgressFunc) error {
+	failures, ctxErr := processRanges(ctx, from, to, pool, state, onProgress, func(rangePrefix string) error {
+		// We basically ignore any error here because we can still process the range even if we can't load the etag
+		etag, err := store.LoadETag(rangePrefix)
+		if err != nil {
+			etag = ""
+		}
+
+		resp, err := client.RequestRange(rangePrefix, etag)
+		if err != nil {
+			return err
+		}
+
+		stats.bytesDownloaded.Add(int64(len(resp.Data)))
+
+		if resp.NotModified {
+			stats.notModified.Add(1)
+
+			return nil
+		}
+
+		if err := store.Save(rangePrefix, resp.ETag, resp.Data); err != nil {
+			return fmt.Errorf("saving range: %w", err)
+		}
+
+		stats.updated.Add(1)
+
+		if s, ok := store.(sizer); ok {
+			if size, err := s.Size(rangePrefix); err == nil {
+				stats.bytesWritten.Add(size)
+			}
+		}
+
+		return nil
+	})
+
+	stats.failed.Add(int64(len(failures)))
+
+	var syncErr error
+
+	if len(failures) > 0 {
+		syncErr = &SyncError{Failures: failures}
+	}
+
+	if ctxErr != nil {
+		return errors.Join(ctxErr, syncErr)
+	}
+
+	return syncErr
+}
+
+// processRanges calls process for all ranges in [from, to) that are not marked as done in the given state, using the
+// given pool.
+// The state gets updated as ranges are completed or fail; failing ranges do not stop the operation, they are returned
+// ordered by their prefix once all other ranges have been processed.
+// If the context gets cancelled, no further ranges are started and its error is returned.
+func processRanges(ctx context.Context, from, to int64, pool *pond.WorkerPool, state *syncState, onProgress ProgressFunc, process func(rangePrefix string) error) ([]*RangeError, error) {
 	var (
-		mErr           error
+		ctxErr         error
+		failures       []*RangeError
 		errLock        syncPkg.Mutex
 		processed      atomic.Int64
 		inFlightSet    = mapset.NewSet[int64]()
 		onProgressLock syncPkg.Mutex
 	)
 
-	processed.Store(from)
+	// Ranges that have been completed in a previous run count as processed, so that progress reporting stays consistent
+	// across interrupted runs.
+	processed.Store(from + state.countDone(from, to))
 
 	for i := from; i < to; i++ {
 		current := i
 
+		if state.isDone(current) {
+			continue
+		}
+
 		// Pool is configured to be non-buffering, i.e., when the context gets canceled, we will finish the jobs
 		// that are currently being processed, but we will not start new ones.
-		if err := ctx.Err(); err != nil {
-			return err
+		if ctxErr = ctx.Err(); ctxErr != nil {
+			break
 		}
 
 		pool.Submit(func() {
@@ -43,23 +113,10 @@ func sync(ctx context.Context, from, to int64, client *hibpClient, store storage
 
 				inFlightSet.Add(current)
 
-				// We basically ignore any error here because we can still process the range even if we can't load the etag
-				etag, err := store.LoadETag(rangePrefix)
-				if err != nil {
-					etag = ""
-				}
-
-				resp, err := client.RequestRange(rangePrefix, etag)
-				if err != nil {
+				if err := process(rangePrefix); err != nil {
 					return err
 				}
 
-				if !resp.NotModified {
-					if err := store.Save(rangePrefix, resp.ETag, resp.Data); err != nil {
-						return fmt.Errorf("saving range: %w", err)
-					}
-				}
-
 				p := processed.Add(1)
 
 				inFlightSet.Remove(current)
@@ -76,27 +133,51 @@ func sync(ctx context.Context, from, to int64, client *hibpClient, store storage
 					}
 				}
 
+				// Only ranges whose step succeeded as a whole are done, others are processed again by the next run
+				state.markDone(current)
+
 				return nil
 			}()
 
 			if err != nil {
+				// A failed range must not pin the lowest in-flight range forever
+				inFlightSet.Remove(current)
+				state.markFailed(current)
+
 				errLock.Lock()
 				defer errLock.Unlock()
 
-				mErr = errors.Join(mErr, fmt.Errorf("processing range %q: %w", rangePrefix, err))
+				failures = append(failures, &RangeError{Prefix: rangePrefix, Err: err})
 			}
 		})
 	}
 
 	pool.StopAndWait()
 
-	return mErr
+	slices.SortFunc(failures, func(a, b *RangeError) int {
+		return strings.Compare(a.Prefix, b.Prefix)
+	})
+
+	return failures, ctxErr
 }
 
 func toRangeString(i int64) string {
 	return fmt.Sprintf("%05X", i)
 }
 
+func parseRangeString(s string) (int64, error) {
+	if len(s) != prefixLength {
+		return 0, fmt.Errorf("invalid range %q: expected %d hex characters", s, prefixLength)
+	}
+
+	r, err := strconv.ParseUint(s, 16, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid range %q: %w", s, err)
+	}
+
+	return int64(r), nil
+}
+
 func lowestInFlight(inFlight mapset.Set[int64], to int64) int64 {
 	lowest := int64(math.MaxInt64)
 
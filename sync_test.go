@@ -9,6 +9,7 @@ import (
 	"reflect"
 	"sync/atomic"
 	"testing"
+	"time"
 
 	"go.uber.org/mock/gomock"
 )
@@ -87,7 +88,7 @@ func TestSync(t *testing.T) {
 	}
 
 	ctrl := gomock.NewController(t)
-	storageMock := NewMockstorage(ctrl)
+	storageMock := NewMockStorage(ctrl)
 
 	storageMock.EXPECT().LoadETag("00000").Return("", nil)
 	storageMock.EXPECT().Save("00000", "etag", []byte("suffix1:1")).Return(nil)
@@ -129,10 +130,23 @@ func TestSync(t *testing.T) {
 	// Create the pool with some arbitrary configuration
 	pool := pond.New(3, 3)
 
-	if err := sync(context.Background(), 0, 12, client, storageMock, pool, progressFn); err != nil {
+	var stats syncStats
+
+	if err := sync(context.Background(), 0, 12, client, storageMock, pool, newSyncState(), &stats, progressFn); err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
 
+	result := stats.result(ModeSHA1, time.Now())
+
+	if result.Updated != 11 || result.NotModified != 1 || result.Failed != 0 || result.Processed() != 12 {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+
+	// The mocked storage does not report sizes, only the downloaded bytes are known
+	if result.BytesDownloaded != 118 || result.BytesWritten != 0 {
+		t.Fatalf("unexpected number of bytes: %+v", result)
+	}
+
 	if callCounter.Load() != 2 {
 		t.Fatalf("unexpected number of calls to progressFn: %d", callCounter.Load())
 	}
@@ -146,6 +160,40 @@ func TestSync(t *testing.T) {
 	}
 }
 
+func TestSyncNTLM(t *testing.T) {
+	httpClient := &http.Client{}
+	gock.InterceptClient(httpClient)
+
+	gock.New(baseURL).
+		Get("/range/00000").
+		MatchParam("mode", "ntlm").
+		Reply(200).
+		AddHeader("ETag", "etag").
+		BodyString("suffix1:1")
+
+	client := &hibpClient{
+		endpoint:   defaultEndpoint,
+		mode:       ModeNTLM,
+		httpClient: httpClient,
+	}
+
+	ctrl := gomock.NewController(t)
+	storageMock := NewMockStorage(ctrl)
+
+	storageMock.EXPECT().LoadETag("00000").Return("", nil)
+	storageMock.EXPECT().Save("00000", "etag", []byte("suffix1:1")).Return(nil)
+
+	pool := pond.New(1, 1)
+
+	if err := sync(context.Background(), 0, 1, client, storageMock, pool, newSyncState(), &syncStats{}, func(_, _, _, _, _ int64) error { return nil }); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if gock.IsPending() {
+		t.Fatalf("there are pending mocks")
+	}
+}
+
 // TODO: We will need further testcases ensuring the library works fine even in error conditions
 
 // Code generated by MockGen. DO NOT EDIT.
@@ -156,31 +204,31 @@ func TestSync(t *testing.T) {
 //	mockgen -source storage.go
 //
 
-// Mockstorage is a mock of storage interface.
-type Mockstorage struct {
+// MockStorage is a mock of Storage interface.
+type MockStorage struct {
 	ctrl     *gomock.Controller
-	recorder *MockstorageMockRecorder
+	recorder *MockStorageMockRecorder
 }
 
-// MockstorageMockRecorder is the mock recorder for Mockstorage.
-type MockstorageMockRecorder struct {
-	mock *Mockstorage
+// MockStorageMockRecorder is the mock recorder for MockStorage.
+type MockStorageMockRecorder struct {
+	mock *MockStorage
 }
 
-// NewMockstorage creates a new mock instance.
-func NewMockstorage(ctrl *gomock.Controller) *Mockstorage {
-	mock := &Mockstorage{ctrl: ctrl}
-	mock.recorder = &MockstorageMockRecorder{mock}
+// NewMockStorage creates a new mock instance.
+func NewMockStorage(ctrl *gomock.Controller) *MockStorage {
+	mock := &MockStorage{ctrl: ctrl}
+	mock.recorder = &MockStorageMockRecorder{mock}
 	return mock
 }
 
 // EXPECT returns an object that allows the caller to indicate expected use.
-func (m *Mockstorage) EXPECT() *MockstorageMockRecorder {
+func (m *MockStorage) EXPECT() *MockStorageMockRecorder {
 	return m.recorder
 }
 
 // LoadData mocks base method.
-func (m *Mockstorage) LoadData(key string) (io.ReadCloser, error) {
+func (m *MockStorage) LoadData(key string) (io.ReadCloser, error) {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "LoadData", key)
 	ret0, _ := ret[0].(io.ReadCloser)
@@ -189,13 +237,13 @@ func (m *Mockstorage) LoadData(key string) (io.ReadCloser, error) {
 }
 
 // LoadData indicates an expected call of LoadData.
-func (mr *MockstorageMockRecorder) LoadData(key any) *gomock.Call {
+func (mr *MockStorageMockRecorder) LoadData(key any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LoadData", reflect.TypeOf((*Mockstorage)(nil).LoadData), key)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LoadData", reflect.TypeOf((*MockStorage)(nil).LoadData), key)
 }
 
 // LoadETag mocks base method.
-func (m *Mockstorage) LoadETag(key string) (string, error) {
+func (m *MockStorage) LoadETag(key string) (string, error) {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "LoadETag", key)
 	ret0, _ := ret[0].(string)
@@ -204,13 +252,13 @@ func (m *Mockstorage) LoadETag(key string) (string, error) {
 }
 
 // LoadETag indicates an expected call of LoadETag.
-func (mr *MockstorageMockRecorder) LoadETag(key any) *gomock.Call {
+func (mr *MockStorageMockRecorder) LoadETag(key any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LoadETag", reflect.TypeOf((*Mockstorage)(nil).LoadETag), key)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LoadETag", reflect.TypeOf((*MockStorage)(nil).LoadETag), key)
 }
 
 // Save mocks base method.
-func (m *Mockstorage) Save(key, etag string, data []byte) error {
+func (m *MockStorage) Save(key, etag string, data []byte) error {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "Save", key, etag, data)
 	ret0, _ := ret[0].(error)
@@ -218,7 +266,7 @@ func (m *Mockstorage) Save(key, etag string, data []byte) error {
 }
 
 // Save indicates an expected call of Save.
-func (mr *MockstorageMockRecorder) Save(key, etag, data any) *gomock.Call {
+func (mr *MockStorageMockRecorder) Save(key, etag, data any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Save", reflect.TypeOf((*Mockstorage)(nil).Save), key, etag, data)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Save", reflect.TypeOf((*MockStorage)(nil).Save), key, etag, data)
 }
@@ -8,9 +8,11 @@ import (
 )
 
 type hibpClient struct {
-	endpoint   string
-	httpClient *http.Client
-	maxRetries int
+	endpoint    string
+	mode        HashMode
+	httpClient  *http.Client
+	maxRetries  int
+	ignoreETags bool
 }
 
 type hibpResponse struct {
@@ -20,12 +22,12 @@ type hibpResponse struct {
 }
 
 func (h *hibpClient) RequestRange(rangePrefix, etag string) (*hibpResponse, error) {
-	req, err := http.NewRequest("GET", h.endpoint+rangePrefix, nil)
+	req, err := http.NewRequest("GET", h.endpoint+rangePrefix+h.mode.queryParameter(), nil)
 	if err != nil {
 		return nil, fmt.Errorf("creating request for range %q: %w", rangePrefix, err)
 	}
 
-	if etag != "" {
+	if etag != "" && !h.ignoreETags {
 		req.Header.Set("If-None-Match", etag)
 	}
 
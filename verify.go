@@ -0,0 +1,236 @@
+package hibp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/alitto/pond"
+	"io/fs"
+	"slices"
+	"strings"
+	syncPkg "sync"
+)
+
+// VerifyReport lists the problems found by Verify.
+type VerifyReport struct {
+	// Mode is the hash family that has been verified.
+	Mode HashMode
+	// Checked is the number of ranges that have been checked.
+	Checked int64
+	// Missing lists the prefixes of the ranges that do not exist in the local dataset.
+	Missing []string
+	// Corrupt lists the ranges that exist but cannot be read or are malformed.
+	Corrupt []*RangeError
+	// Leftovers lists temporary files that have been left behind by interrupted writes.
+	Leftovers []string
+	// Repaired is true if the problems listed above have been repaired, see VerifyWithRepair.
+	Repaired bool
+}
+
+// OK reports whether the dataset is complete and well-formed, or has been repaired successfully.
+func (r *VerifyReport) OK() bool {
+	return r.Repaired || (len(r.Missing) == 0 && len(r.Corrupt) == 0 && len(r.Leftovers) == 0)
+}
+
+// leftoverCleaner is implemented by storages that may leave temporary files behind when being interrupted.
+type leftoverCleaner interface {
+	Leftovers() ([]string, error)
+	RemoveLeftovers() error
+}
+
+// Verify checks the local dataset for completeness and integrity.
+// Every range has to exist, to be readable (i.e., to decompress cleanly) and to consist of lines following the schema
+// "<suffix>:<count>" with upper-case hex suffixes in strictly ascending order.
+// Additionally, no temporary files must have been left behind by interrupted writes.
+// Verifying while syncing might report temporary files that are still in use.
+// The returned error refers to the verification itself; problems of the dataset are listed in the report.
+func (h *HIBP) Verify(ctx context.Context, options ...VerifyOption) (_ *VerifyReport, err error) {
+	config := &verifyConfig{
+		mode:       h.mode,
+		minWorkers: defaultWorkers,
+		lastRange:  defaultLastRange,
+	}
+
+	for _, option := range options {
+		option(config)
+	}
+
+	if config.repair && h.readOnly {
+		return nil, ErrReadOnly
+	}
+
+	ds, err := h.dataset(config.mode)
+	if err != nil {
+		return nil, err
+	}
+
+	unlock, err := ds.readLock()
+	if err != nil {
+		return nil, err
+	}
+	defer releaseLock(unlock, &err)
+
+	report := &VerifyReport{Mode: config.mode}
+
+	var lock syncPkg.Mutex
+
+	pool := pond.New(config.minWorkers, 0, pond.MinWorkers(config.minWorkers))
+
+	for i := int64(0); i <= config.lastRange; i++ {
+		if err := ctx.Err(); err != nil {
+			pool.StopAndWait()
+
+			return nil, err
+		}
+
+		rangePrefix := toRangeString(i)
+
+		pool.Submit(func() {
+			err := verifyRange(ds.store, rangePrefix, config.mode.hashLength()-prefixLength)
+
+			lock.Lock()
+			defer lock.Unlock()
+
+			report.Checked++
+
+			switch {
+			case err == nil:
+			case errors.Is(err, fs.ErrNotExist):
+				report.Missing = append(report.Missing, rangePrefix)
+			default:
+				report.Corrupt = append(report.Corrupt, &RangeError{Prefix: rangePrefix, Err: err})
+			}
+		})
+	}
+
+	pool.StopAndWait()
+
+	slices.Sort(report.Missing)
+	slices.SortFunc(report.Corrupt, func(a, b *RangeError) int {
+		return strings.Compare(a.Prefix, b.Prefix)
+	})
+
+	cleaner, canClean := ds.store.(leftoverCleaner)
+	if canClean {
+		report.Leftovers, err = cleaner.Leftovers()
+		if err != nil {
+			return nil, fmt.Errorf("looking for leftovers: %w", err)
+		}
+	}
+
+	if !config.repair || report.OK() {
+		return report, nil
+	}
+
+	// Repairing locks the data dir exclusively, which is not possible as long as we hold the shared lock; an error
+	// releasing it is returned by the deferred call
+	if unlock() != nil {
+		return report, nil
+	}
+
+	if canClean && len(report.Leftovers) > 0 {
+		if err := removeLeftovers(ds, cleaner); err != nil {
+			return report, fmt.Errorf("removing leftovers: %w", err)
+		}
+	}
+
+	broken := slices.Clone(report.Missing)
+	for _, corrupt := range report.Corrupt {
+		broken = append(broken, corrupt.Prefix)
+	}
+
+	if len(broken) > 0 {
+		syncOptions := append([]SyncOption{
+			SyncWithContext(ctx),
+			SyncWithLastRange(config.lastRange),
+			// The ETag of a corrupt range might still be readable, it must not prevent re-downloading the range.
+			syncWithoutETags(),
+		}, config.syncOptions...)
+
+		// The following options must not be overridden by the caller
+		syncOptions = append(syncOptions, SyncWithMode(config.mode), SyncWithRanges(broken))
+
+		if err := h.Sync(syncOptions...); err != nil {
+			return report, fmt.Errorf("re-fetching broken ranges: %w", err)
+		}
+	}
+
+	report.Repaired = true
+
+	return report, nil
+}
+
+func removeLeftovers(ds *dataset, cleaner leftoverCleaner) (err error) {
+	unlock, err := ds.lock(true)
+	if err != nil {
+		return err
+	}
+	defer releaseLock(unlock, &err)
+
+	return cleaner.RemoveLeftovers()
+}
+
+func verifyRange(store Storage, rangePrefix string, suffixLength int) error {
+	if _, err := store.LoadETag(rangePrefix); err != nil {
+		return err
+	}
+
+	reader, err := store.LoadData(rangePrefix)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	var (
+		previous   []byte
+		lineNumber int
+	)
+
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		lineNumber++
+
+		suffix, count, found := bytes.Cut(scanner.Bytes(), []byte(":"))
+		if !found || len(suffix) != suffixLength || !isUpperHex(suffix) || len(count) == 0 || !isDigits(count) {
+			return fmt.Errorf("line %d is malformed: %q", lineNumber, scanner.Bytes())
+		}
+
+		if previous != nil && bytes.Compare(suffix, previous) <= 0 {
+			return fmt.Errorf("line %d is out of order: %q follows %q", lineNumber, suffix, previous)
+		}
+
+		previous = append(previous[:0], suffix...)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("reading data: %w", err)
+	}
+
+	if lineNumber == 0 {
+		return errors.New("range is empty")
+	}
+
+	return nil
+}
+
+func isUpperHex(b []byte) bool {
+	for _, c := range b {
+		if (c < '0' || c > '9') && (c < 'A' || c > 'F') {
+			return false
+		}
+	}
+
+	return true
+}
+
+func isDigits(b []byte) bool {
+	for _, c := range b {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,115 @@
+package hibp
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestVerify(t *testing.T) {
+	dataDir := t.TempDir()
+
+	h, err := New(WithDataDir(dataDir))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	store := h.datasets[ModeSHA1].store.(*fsStorage)
+
+	validSuffix := func(c string) string { return strings.Repeat(c, 35) }
+
+	for prefix, data := range map[string]string{
+		"00000": validSuffix("0") + ":1\r\n" + validSuffix("A") + ":2",
+		"00001": validSuffix("A") + ":1\r\n" + validSuffix("0") + ":2",
+		"00003": validSuffix("0") + ":1",
+		"00004": "not a valid line",
+	} {
+		if err := store.Save(prefix, "etag", []byte(data)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	// Garbage instead of a zstd stream
+	if err := os.WriteFile(store.filePath("00003"), []byte("garbage"), 0o644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	leftover := store.filePath("00000") + tmpSuffix
+	if err := os.WriteFile(leftover, []byte("partial"), 0o644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	report, err := h.Verify(context.Background(), VerifyWithLastRange(4))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if report.OK() || report.Checked != 5 {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+
+	if !reflect.DeepEqual(report.Missing, []string{"00002"}) {
+		t.Fatalf("unexpected missing ranges: %v", report.Missing)
+	}
+
+	var corrupt []string
+	for _, c := range report.Corrupt {
+		corrupt = append(corrupt, c.Prefix)
+	}
+
+	if !reflect.DeepEqual(corrupt, []string{"00001", "00003", "00004"}) {
+		t.Fatalf("unexpected corrupt ranges: %v", report.Corrupt)
+	}
+
+	if !reflect.DeepEqual(report.Leftovers, []string{leftover}) {
+		t.Fatalf("unexpected leftovers: %v", report.Leftovers)
+	}
+
+	var requested []string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = append(requested, strings.TrimPrefix(r.URL.Path, "/range/"))
+
+		// ETags of corrupt ranges must not be sent, otherwise we would not get any data
+		if r.Header.Get("If-None-Match") != "" {
+			w.WriteHeader(http.StatusNotModified)
+
+			return
+		}
+
+		w.Header().Set("ETag", "new etag")
+		_, _ = w.Write([]byte(validSuffix("B") + ":3"))
+	}))
+	defer server.Close()
+
+	report, err = h.Verify(context.Background(), VerifyWithLastRange(4),
+		VerifyWithRepair(SyncWithEndpoint(server.URL+"/range/"), SyncWithMinWorkers(1)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !report.Repaired || !report.OK() {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+
+	if !reflect.DeepEqual(requested, []string{"00001", "00002", "00003", "00004"}) {
+		t.Fatalf("unexpected ranges requested: %v", requested)
+	}
+
+	if _, err := os.Stat(leftover); !os.IsNotExist(err) {
+		t.Fatalf("expected leftover to be removed: %v", err)
+	}
+
+	report, err = h.Verify(context.Background(), VerifyWithLastRange(4))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !report.OK() || report.Repaired {
+		t.Fatalf("unexpected report after repair: %+v", report)
+	}
+}